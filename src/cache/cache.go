@@ -1,63 +1,314 @@
 package cache
 
 import (
+	"container/list"
+	"hash/fnv"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// entryOverhead adalah perkiraan memori per entry di luar key dan data
+// (elemen list, entry map, header struct)
+const entryOverhead = 128
+
+const defaultShards = 16
+
 // CacheEntry menyimpan data hasil query dengan TTL
 type CacheEntry struct {
 	Data      interface{}
 	ExpiresAt time.Time
 }
 
-// DNSTTLCache adalah cache dengan TTL untuk menyimpan hasil DNS query
+// Options mengatur batas ukuran cache. Nilai 0 berarti tanpa batas
+type Options struct {
+	MaxEntries int   // jumlah entry maksimal
+	MaxBytes   int64 // perkiraan memori maksimal dalam byte
+	Shards     int   // jumlah shard, default 16
+
+	// StaleWindow adalah lama entry kedaluwarsa tetap disimpan untuk
+	// serve-stale (RFC 8767). 0 berarti entry dihapus begitu kedaluwarsa
+	StaleWindow time.Duration
+}
+
+// Stats berisi ukuran dan counter cache
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Expired   uint64 `json:"expired"`
+	StaleHits uint64 `json:"stale_hits"`
+}
+
+type lruItem struct {
+	key   string
+	entry CacheEntry
+	size  int64
+
+	ttl         time.Duration // umur awal entry
+	hits        uint32        // jumlah hit sejak entry terakhir di-Set
+	prefetching bool
+	noStale     bool // tidak dipakai sebagai data stale, misal SERVFAIL
+}
+
+// staleUntil adalah batas entry masih boleh dipakai sebagai data stale
+func (item *lruItem) staleUntil(staleWindow time.Duration) time.Time {
+	if item.noStale {
+		return item.entry.ExpiresAt
+	}
+	return item.entry.ExpiresAt.Add(staleWindow)
+}
+
+// shard adalah satu bagian cache dengan lock dan urutan LRU sendiri
+type shard struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List // depan = paling baru dipakai
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+// DNSTTLCache adalah cache LRU dengan TTL untuk menyimpan hasil DNS query.
+// Map dibagi ke beberapa shard agar lock tidak jadi rebutan
 type DNSTTLCache struct {
-	store map[string]CacheEntry
-	mu    sync.RWMutex
+	shards      []*shard
+	staleWindow time.Duration
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+	staleHits atomic.Uint64
 }
 
-// NewDNSTTLCache membuat instance cache baru
+// NewDNSTTLCache membuat instance cache baru tanpa batas ukuran
 func NewDNSTTLCache() *DNSTTLCache {
-	return &DNSTTLCache{
-		store: make(map[string]CacheEntry),
+	return NewDNSTTLCacheWithOptions(Options{})
+}
+
+// NewDNSTTLCacheWithOptions membuat instance cache baru dengan batas ukuran
+func NewDNSTTLCacheWithOptions(opts Options) *DNSTTLCache {
+	if opts.Shards <= 0 {
+		opts.Shards = defaultShards
+	}
+
+	c := &DNSTTLCache{
+		shards:      make([]*shard, opts.Shards),
+		staleWindow: opts.StaleWindow,
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			items:      make(map[string]*list.Element),
+			lru:        list.New(),
+			maxEntries: divCeil(opts.MaxEntries, opts.Shards),
+			maxBytes:   int64(divCeil(int(opts.MaxBytes), opts.Shards)),
+		}
 	}
+	return c
+}
+
+func divCeil(total, n int) int {
+	if total <= 0 {
+		return 0
+	}
+	return (total + n - 1) / n
+}
+
+func (c *DNSTTLCache) shardFor(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// entrySize memperkirakan memori yang dipakai sebuah entry
+func entrySize(key string, data interface{}) int64 {
+	size := int64(len(key) + entryOverhead)
+	switch d := data.(type) {
+	case []byte:
+		size += int64(len(d))
+	case string:
+		size += int64(len(d))
+	}
+	return size
 }
 
 // Get mengambil data dari cache jika belum kedaluwarsa
 func (c *DNSTTLCache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	data, _, found := c.GetWithTTL(key)
+	return data, found
+}
+
+// GetWithTTL seperti Get, ditambah sisa umur entry di cache
+func (c *DNSTTLCache) GetWithTTL(key string) (interface{}, time.Duration, bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, found := s.items[key]
+	if !found {
+		c.misses.Add(1)
+		return nil, 0, false
+	}
+	item := elem.Value.(*lruItem)
+	remaining := time.Until(item.entry.ExpiresAt)
+	if remaining <= 0 {
+		c.misses.Add(1)
+		return nil, 0, false
+	}
+
+	s.lru.MoveToFront(elem)
+	item.hits++
+	c.hits.Add(1)
+	return item.entry.Data, remaining, true
+}
 
-	entry, found := c.store[key]
-	if !found || time.Now().After(entry.ExpiresAt) {
+// ShouldPrefetch bernilai true satu kali untuk entry populer (minimal minHits
+// hit) yang sudah masuk 10% terakhir umurnya, agar diperbarui sebelum kedaluwarsa
+func (c *DNSTTLCache) ShouldPrefetch(key string, minHits uint32) bool {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, found := s.items[key]
+	if !found {
+		return false
+	}
+	item := elem.Value.(*lruItem)
+	if item.prefetching || item.hits < minHits {
+		return false
+	}
+	remaining := time.Until(item.entry.ExpiresAt)
+	if remaining <= 0 || remaining > item.ttl/10 {
+		return false
+	}
+
+	item.prefetching = true
+	return true
+}
+
+// GetStale mengambil data yang sudah kedaluwarsa tetapi masih dalam
+// stale window, dipakai saat semua upstream gagal. Entry yang disimpan
+// dengan SetNoStale tidak pernah dikembalikan
+func (c *DNSTTLCache) GetStale(key string) (interface{}, bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, found := s.items[key]
+	if !found {
+		return nil, false
+	}
+	item := elem.Value.(*lruItem)
+	if time.Now().After(item.staleUntil(c.staleWindow)) {
 		return nil, false
 	}
-	return entry.Data, true
+	return item.entry.Data, true
 }
 
-// Set menyimpan data ke cache dengan TTL tertentu
+// RecordStaleHit mencatat satu jawaban yang benar-benar dilayani dari data stale
+func (c *DNSTTLCache) RecordStaleHit() {
+	c.staleHits.Add(1)
+}
+
+// Set menyimpan data ke cache dengan TTL tertentu. Jika batas ukuran
+// terlampaui, entry yang paling lama tidak dipakai dibuang
 func (c *DNSTTLCache) Set(key string, data interface{}, ttl uint32) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	lifetime := time.Duration(ttl) * time.Second
+	c.set(key, data, time.Now().Add(lifetime), lifetime, false)
+}
+
+// SetNoStale seperti Set, tetapi entry tidak dipakai sebagai data stale
+// setelah kedaluwarsa. Dipakai untuk kegagalan seperti SERVFAIL, yang
+// tidak boleh menghalangi query ulang ke upstream selama stale window
+func (c *DNSTTLCache) SetNoStale(key string, data interface{}, ttl uint32) {
+	lifetime := time.Duration(ttl) * time.Second
+	c.set(key, data, time.Now().Add(lifetime), lifetime, true)
+}
+
+func (c *DNSTTLCache) set(key string, data interface{}, expiresAt time.Time, lifetime time.Duration, noStale bool) {
+	s := c.shardFor(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	c.store[key] = CacheEntry{
+	entry := CacheEntry{
 		Data:      data,
-		ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+		ExpiresAt: expiresAt,
+	}
+	size := entrySize(key, data)
+
+	if elem, found := s.items[key]; found {
+		item := elem.Value.(*lruItem)
+		s.bytes += size - item.size
+		item.entry = entry
+		item.size = size
+		item.ttl = lifetime
+		item.hits = 0
+		item.prefetching = false
+		item.noStale = noStale
+		s.lru.MoveToFront(elem)
+	} else {
+		s.items[key] = s.lru.PushFront(&lruItem{key: key, entry: entry, size: size, ttl: lifetime, noStale: noStale})
+		s.bytes += size
+	}
+
+	for s.overLimit() {
+		oldest := s.lru.Back()
+		if oldest == nil || oldest == s.lru.Front() {
+			break
+		}
+		s.remove(oldest)
+		c.evictions.Add(1)
 	}
 }
 
-// Cleanup menghapus entry cache yang kedaluwarsa
-func (c *DNSTTLCache) Cleanup() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+func (s *shard) overLimit() bool {
+	return (s.maxEntries > 0 && s.lru.Len() > s.maxEntries) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
 
+func (s *shard) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*lruItem)
+	delete(s.items, item.key)
+	s.bytes -= item.size
+}
+
+// Cleanup menghapus entry cache yang kedaluwarsa dan sudah lewat stale window
+func (c *DNSTTLCache) Cleanup() {
 	now := time.Now()
-	for k, v := range c.store {
-		if now.After(v.ExpiresAt) {
-			delete(c.store, k)
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.lru.Back(); elem != nil; {
+			prev := elem.Prev()
+			if now.After(elem.Value.(*lruItem).staleUntil(c.staleWindow)) {
+				s.remove(elem)
+				c.expired.Add(1)
+			}
+			elem = prev
 		}
+		s.mu.Unlock()
+	}
+}
+
+// Stats mengembalikan ukuran dan counter cache saat ini
+func (c *DNSTTLCache) Stats() Stats {
+	stats := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Expired:   c.expired.Load(),
+		StaleHits: c.staleHits.Load(),
 	}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		stats.Entries += s.lru.Len()
+		stats.Bytes += s.bytes
+		s.mu.Unlock()
+	}
+	return stats
 }
 
 // StartCleanupLoop menjalankan proses pembersihan cache otomatis
@@ -66,6 +317,10 @@ func (c *DNSTTLCache) StartCleanupLoop(interval time.Duration) {
 		for {
 			time.Sleep(interval)
 			c.Cleanup()
+
+			stats := c.Stats()
+			log.Printf("[INFO] Cache: %d entries, %d bytes, %d hits, %d misses, %d evictions, %d expired, %d stale hits",
+				stats.Entries, stats.Bytes, stats.Hits, stats.Misses, stats.Evictions, stats.Expired, stats.StaleHits)
 		}
 	}()
 }
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetStale(t *testing.T) {
+	c := NewDNSTTLCacheWithOptions(Options{StaleWindow: time.Hour})
+	past := time.Now().Add(-time.Minute)
+	c.set("answer", []byte("answer"), past, time.Minute, false)
+	c.set("servfail", []byte("servfail"), past, time.Minute, true)
+
+	if _, found := c.Get("answer"); found {
+		t.Error("Get() returned an expired entry")
+	}
+	if data, found := c.GetStale("answer"); !found || string(data.([]byte)) != "answer" {
+		t.Errorf("GetStale(answer) = %v, %v, want stale data", data, found)
+	}
+	if _, found := c.GetStale("servfail"); found {
+		t.Error("GetStale(servfail) returned an entry stored with SetNoStale")
+	}
+
+	c.Cleanup()
+	if stats := c.Stats(); stats.Entries != 1 || stats.Expired != 1 {
+		t.Errorf("Cleanup() left %d entries, expired %d, want 1 and 1", stats.Entries, stats.Expired)
+	}
+}
+
+func TestSetClearsNoStale(t *testing.T) {
+	c := NewDNSTTLCacheWithOptions(Options{StaleWindow: time.Hour})
+	past := time.Now().Add(-time.Second)
+	c.set("key", []byte("servfail"), past, time.Minute, true)
+	c.set("key", []byte("answer"), past, time.Minute, false)
+
+	if _, found := c.GetStale("key"); !found {
+		t.Error("GetStale() = not found after an answer replaced a SERVFAIL entry")
+	}
+}
+
+func TestGetStaleDoesNotCountStaleHit(t *testing.T) {
+	c := NewDNSTTLCacheWithOptions(Options{StaleWindow: time.Hour})
+	c.set("key", []byte("answer"), time.Now().Add(-time.Second), time.Minute, false)
+
+	c.GetStale("key")
+	if hits := c.Stats().StaleHits; hits != 0 {
+		t.Errorf("StaleHits after GetStale = %d, want 0", hits)
+	}
+	c.RecordStaleHit()
+	if hits := c.Stats().StaleHits; hits != 1 {
+		t.Errorf("StaleHits after RecordStaleHit = %d, want 1", hits)
+	}
+}
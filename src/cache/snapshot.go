@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/gob"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotEntry adalah satu entry cache di file snapshot. Waktu kedaluwarsa
+// disimpan absolut agar TTL tetap benar setelah restart
+type snapshotEntry struct {
+	Key       string
+	Data      []byte
+	ExpiresAt time.Time
+	TTL       time.Duration
+	NoStale   bool
+}
+
+// SaveSnapshot menulis semua entry cache ke file (format gob). File ditulis
+// ke file sementara lalu di-rename, sehingga snapshot lama tidak rusak jika gagal
+func (c *DNSTTLCache) SaveSnapshot(path string) error {
+	var entries []snapshotEntry
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+			item := elem.Value.(*lruItem)
+			data, ok := item.entry.Data.([]byte)
+			if !ok {
+				continue
+			}
+			entries = append(entries, snapshotEntry{
+				Key:       item.key,
+				Data:      data,
+				ExpiresAt: item.entry.ExpiresAt,
+				TTL:       item.ttl,
+				NoStale:   item.noStale,
+			})
+		}
+		s.mu.Unlock()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot memuat entry yang belum kedaluwarsa dari file snapshot dan
+// mengembalikan jumlah entry yang dimuat
+func (c *DNSTTLCache) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var entries []snapshotEntry
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	loaded := 0
+	for _, e := range entries {
+		if !e.ExpiresAt.After(now) {
+			continue
+		}
+		c.set(e.Key, e.Data, e.ExpiresAt, e.TTL, e.NoStale)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// StartSnapshotLoop menyimpan snapshot cache secara berkala
+func (c *DNSTTLCache) StartSnapshotLoop(path string, interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := c.SaveSnapshot(path); err != nil {
+				log.Printf("[ERROR] Failed to save cache snapshot: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TTLPolicy menentukan berapa lama sebuah response DNS disimpan di cache
+type TTLPolicy struct {
+	NegativeMaxTTL uint32 // batas atas TTL NXDOMAIN/NODATA, 0 berarti tanpa batas
+	ServFailTTL    uint32 // SERVFAIL disimpan sebentar agar outage tidak memperbesar beban
+}
+
+// TTL menghitung lama cache untuk sebuah response
+func (p TTLPolicy) TTL(msg *dns.Msg) uint32 {
+	switch msg.Rcode {
+	case dns.RcodeSuccess:
+		if len(msg.Answer) > 0 {
+			return minAnswerTTL(msg)
+		}
+		return p.negativeTTL(msg) // NODATA
+	case dns.RcodeNameError:
+		return p.negativeTTL(msg)
+	case dns.RcodeServerFailure:
+		return p.ServFailTTL
+	}
+	return 0
+}
+
+// negativeTTL mengikuti RFC 2308: min(TTL SOA, SOA MINIMUM) dari authority.
+// Tanpa SOA, jawaban negatif tidak di-cache
+func (p TTLPolicy) negativeTTL(msg *dns.Msg) uint32 {
+	for _, rr := range msg.Ns {
+		soa, ok := rr.(*dns.SOA)
+		if !ok {
+			continue
+		}
+		ttl := min(soa.Hdr.Ttl, soa.Minttl)
+		if p.NegativeMaxTTL > 0 && ttl > p.NegativeMaxTTL {
+			ttl = p.NegativeMaxTTL
+		}
+		return ttl
+	}
+	return 0
+}
+
+// minAnswerTTL mengembalikan TTL terkecil dari semua record answer,
+// agar tidak ada record yang disajikan melewati masa berlakunya
+func minAnswerTTL(msg *dns.Msg) uint32 {
+	ttl := msg.Answer[0].Header().Ttl
+	for _, rr := range msg.Answer[1:] {
+		ttl = min(ttl, rr.Header().Ttl)
+	}
+	return ttl
+}
+
+// AgeTTL menyesuaikan TTL response yang disajikan dari cache. Semua record
+// dikurangi umur entry di cache, dan TTL authority pada jawaban negatif
+// dibatasi sisa umur entry agar client tidak menyimpannya lebih lama
+func (p TTLPolicy) AgeTTL(msg *dns.Msg, remaining time.Duration) {
+	lifetime := p.TTL(msg)
+	remainingSec := uint32(remaining / time.Second)
+	if lifetime <= remainingSec {
+		return
+	}
+	elapsed := lifetime - remainingSec
+	negative := len(msg.Answer) == 0
+
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl > elapsed {
+				hdr.Ttl -= elapsed
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+
+	if negative {
+		for _, rr := range msg.Ns {
+			rr.Header().Ttl = min(rr.Header().Ttl, remainingSec)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package doh
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// minBootstrapTTL adalah batas bawah lama hasil bootstrap disimpan
+const minBootstrapTTL = 60 * time.Second
+
+// bootstrap me-resolve hostname resolver upstream tanpa lewat resolver sistem,
+// yang bisa saja go.blok.doh sendiri (loop). Hostname dipetakan ke IP tetap
+// atau ditanyakan ke server DNS bootstrap; SNI dan verifikasi sertifikat
+// tetap memakai hostname
+type bootstrap struct {
+	ips     []net.IP // IP tetap dari config
+	servers []string // server DNS bootstrap, "udp://host:port" atau "tcp://host:port"
+
+	mu      sync.Mutex
+	host    string
+	cached  []net.IP
+	expires time.Time
+}
+
+// newBootstrap membuat bootstrap dari entry config: IP ("94.140.14.14") atau
+// server DNS ("udp://9.9.9.9:53", "tcp://9.9.9.9"). Nilai nil berarti tanpa bootstrap
+func newBootstrap(entries []string) (*bootstrap, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	b := &bootstrap{}
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			b.ips = append(b.ips, ip)
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || net.ParseIP(u.Hostname()) == nil {
+			return nil, fmt.Errorf("invalid bootstrap %q: must be an IP or udp://ip:port or tcp://ip:port", entry)
+		}
+		b.servers = append(b.servers, u.Scheme+"://"+hostPort(u, "53"))
+	}
+	return b, nil
+}
+
+// lookup mengembalikan IP untuk host. Jawaban server bootstrap disimpan
+// sesuai TTL-nya, dan tetap dipakai jika server bootstrap sedang gagal
+func (b *bootstrap) lookup(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if len(b.ips) > 0 {
+		return b.ips, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.host == host && time.Now().Before(b.expires) {
+		return b.cached, nil
+	}
+
+	ips, ttl, err := b.resolve(host)
+	if err != nil {
+		if b.host == host && len(b.cached) > 0 {
+			log.Printf("[WARN] Bootstrap lookup for %s failed, using previous addresses: %v", host, err)
+			return b.cached, nil
+		}
+		return nil, err
+	}
+	b.host = host
+	b.cached = ips
+	b.expires = time.Now().Add(max(ttl, minBootstrapTTL))
+	return ips, nil
+}
+
+// resolve menanyakan A dan AAAA host ke server bootstrap satu per satu
+func (b *bootstrap) resolve(host string) ([]net.IP, time.Duration, error) {
+	var lastErr error
+	for _, server := range b.servers {
+		network, addr, _ := strings.Cut(server, "://")
+		client := &dns.Client{Net: network, Timeout: upstreamTimeout}
+
+		var ips []net.IP
+		var ttl uint32
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			query := new(dns.Msg)
+			query.SetQuestion(dns.Fqdn(host), qtype)
+			reply, _, err := client.Exchange(query, addr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, rr := range reply.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					ips = append(ips, rr.A)
+				case *dns.AAAA:
+					ips = append(ips, rr.AAAA)
+				default:
+					continue
+				}
+				if ttl == 0 || rr.Header().Ttl < ttl {
+					ttl = rr.Header().Ttl
+				}
+			}
+		}
+		if len(ips) > 0 {
+			log.Printf("[INFO] Bootstrap resolved %s to %v via %s", host, ips, server)
+			return ips, time.Duration(ttl) * time.Second, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found")
+	}
+	return nil, 0, fmt.Errorf("bootstrap lookup for %s failed: %w", host, lastErr)
+}
+
+// resolveAddr mengganti hostname pada "host:port" dengan IP pertama hasil bootstrap
+func (b *bootstrap) resolveAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ips, err := b.lookup(host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+// DialContext membuka koneksi ke salah satu IP hasil bootstrap, dipakai
+// sebagai pengganti dialer bawaan http.Transport
+func (b *bootstrap) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := b.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: upstreamTimeout}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
@@ -10,48 +10,81 @@ import (
 	"net"
 	"net/http"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Format request ke resolver upstream
+const (
+	FormatJSON = "json" // ?name=&type=, application/dns-json
+	FormatWire = "wire" // RFC 8484, application/dns-message
 )
 
 type Resolver struct {
 	ID     string
-	URL    string
+	URL    string // https:// (DoH), tls:// (DoT), tcp:// atau udp:// (DNS biasa)
 	Weight int
+	Format string // json (default) atau wire
+	Method string // GET (default) atau POST, hanya untuk format wire
+
+	// Pins adalah SHA-256 SPKI sertifikat resolver (base64, boleh diawali "sha256/");
+	// salah satunya harus ada di rantai sertifikat. CA adalah path CA bundle PEM
+	// pengganti CA sistem. Keduanya hanya untuk https:// dan tls://
+	Pins []string
+	CA   string
+
+	// Bootstrap dipakai untuk me-resolve hostname di URL tanpa resolver sistem:
+	// IP ("94.140.14.14") atau server DNS ("udp://9.9.9.9:53", "tcp://9.9.9.9")
+	Bootstrap []string
 }
 
 type DOHResponse struct {
-	Status   int  `json:"Status"`
-	TC       bool `json:"TC"`
-	RD       bool `json:"RD"`
-	RA       bool `json:"RA"`
-	AD       bool `json:"AD"`
-	CD       bool `json:"CD"`
-	Question []struct {
-		Name string `json:"name"`
-		Type int    `json:"type"`
-	} `json:"Question"`
-	Answer []struct {
-		Name string `json:"name"`
-		Type int    `json:"type"`
-		TTL  int    `json:"TTL"`
-		Data string `json:"data"`
-	} `json:"Answer"`
-	Authority []struct {
-		Name string `json:"name"`
-		Type int    `json:"type"`
-		TTL  int    `json:"TTL"`
-		Data string `json:"data"`
-	} `json:"Authority"`
-	Comment json.RawMessage `json:"Comment,omitempty"` // RawMessage for flexibility
-}
-
-type DOHClient struct {
+	Status    int             `json:"Status"`
+	TC        bool            `json:"TC"`
+	RD        bool            `json:"RD"`
+	RA        bool            `json:"RA"`
+	AD        bool            `json:"AD"`
+	CD        bool            `json:"CD"`
+	Question  []DOHQuestion   `json:"Question"`
+	Answer    []DOHRecord     `json:"Answer"`
+	Authority []DOHRecord     `json:"Authority"`
+	Comment   json.RawMessage `json:"Comment,omitempty"` // RawMessage for flexibility
+
+	// Wire berisi response dns.Msg utuh dari resolver berformat wire;
+	// jika terisi, field JSON di atas tidak dipakai
+	Wire []byte `json:"Wire,omitempty"`
+}
+
+type DOHQuestion struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+}
+
+type DOHRecord struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	TTL  int    `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// Pool adalah kumpulan resolver upstream dengan pemilihan, failover dan
+// health check. Tiap resolver bisa memakai protokol berbeda (lihat Upstream)
+type Pool struct {
 	Resolvers []Resolver
-	Client    *http.Client
 
-	mu          sync.Mutex
-	totalWeight int
+	strategy   string
+	rrNext     atomic.Uint32     // posisi berikutnya untuk strategi round_robin
+	upstreams  []Upstream        // sejajar dengan Resolvers
+	health     []*resolverHealth // sejajar dengan Resolvers
+	healthOpts HealthOptions
+}
+
+// Options mengatur strategi pemilihan resolver dan health check
+type Options struct {
+	Strategy string // salah satu konstanta Strategy*, default weighted
+	Health   HealthOptions
 }
 type ResolverInfo struct {
 	Resolver    string `json:"resolver"`
@@ -78,6 +111,12 @@ func (d *DOHResponse) GetComment() []string {
 	return comments
 }
 
+// isFinal bernilai true jika response adalah jawaban sah dari resolver yang
+// sehat, termasuk jawaban negatif (NXDOMAIN/NODATA) yang tidak perlu failover
+func (d *DOHResponse) isFinal() bool {
+	return d.Status == dns.RcodeSuccess || d.Status == dns.RcodeNameError
+}
+
 func getECSSubnet(ip net.IP, prefixLen int) string {
 	if ip.To4() != nil {
 		ip = ip.Mask(net.CIDRMask(prefixLen, 32))
@@ -110,107 +149,235 @@ func isPrivateIP(ip net.IP) bool {
 	return false
 }
 
-func NewDOHClient(resolvers []Resolver) *DOHClient {
-	totalWeight := 0
-	for _, r := range resolvers {
-		totalWeight += r.Weight
+// NewPool membuat Pool dengan strategi weighted dan health check default
+func NewPool(resolvers []Resolver) *Pool {
+	return NewPoolWithOptions(resolvers, Options{})
+}
+
+// NewPoolWithOptions membuat Pool dengan strategi dan health check tertentu
+func NewPoolWithOptions(resolvers []Resolver, opts Options) *Pool {
+	opts.Health.setDefaults()
+	switch opts.Strategy {
+	case StrategyWeighted, StrategyRoundRobin, StrategyLatency, StrategyParallel, StrategyHedged:
+	case "":
+		opts.Strategy = StrategyWeighted
+	default:
+		log.Printf("[WARN] Unknown resolver strategy %q, using %s", opts.Strategy, StrategyWeighted)
+		opts.Strategy = StrategyWeighted
 	}
 
-	transport := &http.Transport{
-		MaxIdleConns:       100,
-		IdleConnTimeout:    90 * time.Second,
-		DisableCompression: true,
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS13,
-		},
+	upstreams := make([]Upstream, len(resolvers))
+	health := make([]*resolverHealth, len(resolvers))
+	for i, resolver := range resolvers {
+		upstream, err := newUpstream(resolver)
+		if err != nil {
+			// resolver dengan URL salah selalu gagal, sehingga segera ejected
+			log.Printf("[ERROR] Resolver [%s]: %v", resolver.ID, err)
+			upstream = brokenUpstream{err: err}
+		}
+		upstreams[i] = upstream
+		health[i] = &resolverHealth{}
 	}
 
-	client := &http.Client{
-		Timeout:   5 * time.Second,
-		Transport: transport,
+	return &Pool{
+		Resolvers:  resolvers,
+		strategy:   opts.Strategy,
+		upstreams:  upstreams,
+		health:     health,
+		healthOpts: opts.Health,
 	}
+}
 
-	return &DOHClient{
-		Resolvers:   resolvers,
-		Client:      client,
-		totalWeight: totalWeight,
+// getNextResolver memilih index resolver sesuai strategi di antara resolver
+// sehat yang belum dicoba. Jika semua resolver sehat sudah dicoba atau
+// ejected, resolver ejected tetap dipakai daripada tidak menjawab sama sekali
+func (d *Pool) getNextResolver(tried map[int]bool) int {
+	candidates := d.candidates(tried)
+	switch d.strategy {
+	case StrategyRoundRobin:
+		return d.pickRoundRobin(candidates)
+	case StrategyLatency:
+		return d.pickLowestLatency(candidates)
+	default:
+		return d.pickWeighted(candidates)
 	}
 }
 
-func (d *DOHClient) getNextResolver() Resolver {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// candidates mengembalikan index resolver sehat yang belum dicoba, atau semua
+// resolver yang belum dicoba jika tidak ada yang sehat
+func (d *Pool) candidates(tried map[int]bool) []int {
+	var candidates []int
+	for i := range d.Resolvers {
+		if !tried[i] && d.health[i].healthy() {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range d.Resolvers {
+			if !tried[i] {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	return candidates
+}
 
-	if d.totalWeight == 0 || len(d.Resolvers) == 0 {
-		return d.Resolvers[rand.Intn(len(d.Resolvers))] // Fallback to random
+// pickWeighted memilih resolver secara weighted random
+func (d *Pool) pickWeighted(candidates []int) int {
+	totalWeight := 0
+	for _, i := range candidates {
+		totalWeight += d.Resolvers[i].Weight
+	}
+	if totalWeight == 0 {
+		return candidates[rand.Intn(len(candidates))] // Fallback to random
 	}
 
-	randVal := rand.Intn(d.totalWeight)
-	for _, r := range d.Resolvers {
-		if randVal < r.Weight {
-			return r
+	randVal := rand.Intn(totalWeight)
+	for _, i := range candidates {
+		if randVal < d.Resolvers[i].Weight {
+			return i
 		}
-		randVal -= r.Weight
+		randVal -= d.Resolvers[i].Weight
 	}
-	return d.Resolvers[0] // Fallback if error
+	return candidates[0] // Fallback if error
 }
 
-func (d *DOHClient) Query(domain string, qtype uint16, clientIP string) (*DOHResponse, ResolverInfo, error) {
+// Query mengirim query client ke resolver sesuai strategi, dengan failover.
+// Format request mengikuti opsi format tiap resolver: json atau wire
+func (d *Pool) Query(req *dns.Msg, clientIP string) (*DOHResponse, ResolverInfo, error) {
 	if len(d.Resolvers) == 0 {
 		return nil, ResolverInfo{}, fmt.Errorf("no resolvers available")
 	}
 
-	domain = strings.TrimSuffix(domain, ".")
-	qtypeStr := fmt.Sprintf("%d", qtype)
+	switch d.strategy {
+	case StrategyParallel, StrategyHedged:
+		return d.race(req, clientIP)
+	default:
+		return d.sequential(req, clientIP)
+	}
+}
 
-	for i := 0; i < len(d.Resolvers); i++ {
-		resolver := d.getNextResolver()
+// sequential mencoba resolver satu per satu sampai ada jawaban final
+func (d *Pool) sequential(req *dns.Msg, clientIP string) (*DOHResponse, ResolverInfo, error) {
+	domain := strings.TrimSuffix(req.Question[0].Name, ".")
 
-		var url string
-		if isPrivateIP(net.ParseIP(clientIP)) {
-			url = fmt.Sprintf("%s?name=%s&type=%s", resolver.URL, domain, qtypeStr)
-		} else {
-			clientSubnet := getECSSubnet(net.ParseIP(clientIP), 24)
-			url = fmt.Sprintf("%s?name=%s&type=%s&edns_client_subnet=%s", resolver.URL, domain, qtypeStr, clientSubnet)
-		}
+	// response gagal terakhir (SERVFAIL, REFUSED, ...) dikembalikan jika semua
+	// resolver gagal, agar rcode-nya sampai ke client
+	var lastResp *DOHResponse
+	var lastInfo ResolverInfo
+
+	tried := make(map[int]bool, len(d.Resolvers))
+	for len(tried) < len(d.Resolvers) {
+		i := d.getNextResolver(tried)
+		tried[i] = true
 
-		// log.Printf("[DEBUG] Querying resolver [%s] %s: %s", resolver.ID, resolver.URL, url)
-		log.Printf("[DEBUG] Querying resolver [%s]: %s", resolver.ID, url)
+		resolver := d.Resolvers[i]
 		resolverInfo := ResolverInfo{
 			Resolver:    resolver.ID,
 			ResolverURL: resolver.URL,
 		}
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Printf("[ERROR] Failed to create request: %v", err)
-			continue
-		}
-
-		req.Header.Set("Accept", "application/dns-json")
 
-		resp, err := d.Client.Do(req)
+		dohResp, latency, err := d.exchange(i, req, clientIP)
+		d.recordResult(i, latency, resultErr(dohResp, err))
 		if err != nil {
-			log.Printf("[ERROR] Failed to send request: %v", err)
+			log.Printf("[ERROR] Resolver [%s] failed: %v", resolver.ID, err)
 			continue
 		}
-		defer resp.Body.Close()
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("[ERROR] Failed to read response: %v", err)
-			continue
+		if dohResp.isFinal() {
+			return dohResp, resolverInfo, nil
 		}
+		log.Printf("[WARN] Resolver [%s] answered %s for %s, trying next resolver", resolver.ID, dns.RcodeToString[dohResp.Status], domain)
+		lastResp, lastInfo = dohResp, resolverInfo
+	}
 
-		var dohResp DOHResponse
-		if err := json.Unmarshal(body, &dohResp); err != nil {
-			log.Printf("[ERROR] Failed to parse JSON response: %v", err)
-			continue
-		}
+	if lastResp != nil {
+		return lastResp, lastInfo, nil
+	}
+	return nil, ResolverInfo{}, fmt.Errorf("all resolvers failed for domain: %s", domain)
+}
 
-		if len(dohResp.Answer) > 0 || len(dohResp.Authority) > 0 {
-			return &dohResp, resolverInfo, nil
-		}
+// exchange mengirim satu query ke resolver ke-i dan mengukur latency
+func (d *Pool) exchange(i int, req *dns.Msg, clientIP string) (*DOHResponse, time.Duration, error) {
+	start := time.Now()
+	dohResp, err := d.upstreams[i].Exchange(req, clientIP)
+	return dohResp, time.Since(start), err
+}
+
+// dohUpstream adalah resolver DNS-over-HTTPS, dengan format json atau wire.
+// http.Client-nya memakai ulang koneksi dan HTTP/2 untuk query bersamaan
+type dohUpstream struct {
+	resolver Resolver
+	client   *http.Client
+}
+
+func newDOHUpstream(resolver Resolver, tlsConfig *tls.Config, boot *bootstrap) *dohUpstream {
+	transport := &http.Transport{
+		MaxIdleConns:       100,
+		IdleConnTimeout:    90 * time.Second,
+		DisableCompression: true,
+		ForceAttemptHTTP2:  true,
+		TLSClientConfig:    tlsConfig,
+	}
+	// SNI dan verifikasi sertifikat tetap memakai hostname dari URL;
+	// hanya alamat tujuan koneksi yang diambil dari bootstrap
+	if boot != nil {
+		transport.DialContext = boot.DialContext
+	}
+
+	return &dohUpstream{
+		resolver: resolver,
+		client: &http.Client{
+			Timeout:   upstreamTimeout,
+			Transport: transport,
+		},
+	}
+}
+
+func (u *dohUpstream) Exchange(req *dns.Msg, clientIP string) (*DOHResponse, error) {
+	if u.resolver.Format == FormatWire {
+		return u.queryWire(req, clientIP)
+	}
+	domain := strings.TrimSuffix(req.Question[0].Name, ".")
+	return u.queryJSON(domain, req.Question[0].Qtype, clientIP)
+}
+
+// queryJSON memakai dialek JSON ?name=&type= (application/dns-json)
+func (u *dohUpstream) queryJSON(domain string, qtype uint16, clientIP string) (*DOHResponse, error) {
+	resolver := u.resolver
+
+	qtypeStr := fmt.Sprintf("%d", qtype)
+
+	var url string
+	if isPrivateIP(net.ParseIP(clientIP)) {
+		url = fmt.Sprintf("%s?name=%s&type=%s", resolver.URL, domain, qtypeStr)
+	} else {
+		clientSubnet := getECSSubnet(net.ParseIP(clientIP), 24)
+		url = fmt.Sprintf("%s?name=%s&type=%s&edns_client_subnet=%s", resolver.URL, domain, qtypeStr, clientSubnet)
+	}
+
+	log.Printf("[DEBUG] Querying resolver [%s]: %s", resolver.ID, url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/dns-json")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	return nil, ResolverInfo{}, fmt.Errorf("all resolvers failed or no answer for domain: %s", domain)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var dohResp DOHResponse
+	if err := json.Unmarshal(body, &dohResp); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return &dohResp, nil
 }
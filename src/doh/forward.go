@@ -0,0 +1,62 @@
+package doh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ForwardRule meneruskan query untuk domain suffix tertentu (conditional
+// forwarding) ke grup resolver bernama, misal zona internal ke DNS LAN
+type ForwardRule struct {
+	Suffix string
+	Group  string
+}
+
+type forwardRoute struct {
+	suffix string // FQDN huruf kecil
+	rule   string // "suffix -> group", dicatat di DNSLog
+	pool   *Pool
+}
+
+// Forwarder memilih grup resolver berdasarkan suffix terpanjang yang cocok
+type Forwarder struct {
+	routes []forwardRoute
+}
+
+// NewForwarder membuat Forwarder dari daftar rule dan grup resolver yang dirujuknya
+func NewForwarder(rules []ForwardRule, groups map[string]*Pool) (*Forwarder, error) {
+	f := &Forwarder{}
+	for _, rule := range rules {
+		pool, ok := groups[rule.Group]
+		if !ok {
+			return nil, fmt.Errorf("forwarding rule %q refers to unknown group %q", rule.Suffix, rule.Group)
+		}
+		suffix := strings.ToLower(dns.Fqdn(rule.Suffix))
+		f.routes = append(f.routes, forwardRoute{
+			suffix: suffix,
+			rule:   fmt.Sprintf("%s -> %s", strings.TrimSuffix(suffix, "."), rule.Group),
+			pool:   pool,
+		})
+	}
+
+	// suffix terpanjang dicek lebih dulu
+	sort.SliceStable(f.routes, func(i, j int) bool {
+		return len(f.routes[i].suffix) > len(f.routes[j].suffix)
+	})
+	return f, nil
+}
+
+// Match mengembalikan grup resolver dan rule untuk nama domain, atau ok=false
+// jika tidak ada rule yang cocok
+func (f *Forwarder) Match(name string) (pool *Pool, rule string, ok bool) {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, route := range f.routes {
+		if route.suffix == "." || name == route.suffix || strings.HasSuffix(name, "."+route.suffix) {
+			return route.pool, route.rule, true
+		}
+	}
+	return nil, "", false
+}
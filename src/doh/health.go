@@ -0,0 +1,244 @@
+package doh
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Nilai default HealthOptions
+const (
+	defaultFailureThreshold = 3
+	defaultBaseBackoff      = 5 * time.Second
+	defaultMaxBackoff       = 5 * time.Minute
+	defaultProbeName        = "example.com."
+
+	// latencyAlpha adalah bobot sampel terbaru pada rata-rata latency (EWMA)
+	latencyAlpha = 0.3
+
+	// latencySamples adalah jumlah sampel latency terakhir untuk menghitung p90
+	latencySamples = 64
+)
+
+// HealthOptions mengatur kapan resolver dikeluarkan (ejected) dari pemilihan
+// dan kapan dicoba lagi oleh probe. Nilai 0 berarti pakai default
+type HealthOptions struct {
+	FailureThreshold int           // kegagalan berturut-turut sebelum ejection, default 3
+	BaseBackoff      time.Duration // lama ejection pertama, berlipat dua tiap ejection berikutnya; default 5 detik
+	MaxBackoff       time.Duration // batas lama ejection, default 5 menit
+	ProbeName        string        // nama yang ditanyakan probe, default example.com
+}
+
+// ResolverStats berisi statistik dan status kesehatan satu resolver
+type ResolverStats struct {
+	ID                  string     `json:"id"`
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Successes           uint64     `json:"successes"`
+	Errors              uint64     `json:"errors"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LatencyMs           float64    `json:"latency_ms"` // rata-rata bergerak (EWMA)
+	LastError           string     `json:"last_error,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"` // jadwal probe berikutnya jika ejected
+}
+
+// resolverHealth mencatat hasil query ke satu resolver
+type resolverHealth struct {
+	mu sync.Mutex
+
+	successes           uint64
+	errors              uint64
+	consecutiveFailures int
+	latency             time.Duration // EWMA
+	lastError           string
+
+	samples     [latencySamples]time.Duration // ring buffer latency terakhir
+	sampleCount int
+
+	ejected   bool
+	ejections int // jumlah ejection berturut-turut, untuk exponential backoff
+	retryAt   time.Time
+}
+
+func (o *HealthOptions) setDefaults() {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = defaultFailureThreshold
+	}
+	if o.BaseBackoff <= 0 {
+		o.BaseBackoff = defaultBaseBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.ProbeName == "" {
+		o.ProbeName = defaultProbeName
+	}
+	o.ProbeName = dns.Fqdn(o.ProbeName)
+}
+
+func (h *resolverHealth) healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.ejected
+}
+
+// record mencatat hasil satu query dan mengembalikan perubahan status:
+// restored jika resolver yang ejected kembali sehat, ejectedFor > 0 jika
+// resolver baru saja (atau kembali) dikeluarkan
+func (h *resolverHealth) record(latency time.Duration, err error, opts HealthOptions) (restored bool, ejectedFor time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.successes++
+		h.consecutiveFailures = 0
+		if h.latency == 0 {
+			h.latency = latency
+		} else {
+			h.latency = time.Duration(latencyAlpha*float64(latency) + (1-latencyAlpha)*float64(h.latency))
+		}
+		h.samples[h.sampleCount%latencySamples] = latency
+		h.sampleCount++
+		restored = h.ejected
+		h.ejected = false
+		h.ejections = 0
+		return restored, 0
+	}
+
+	h.errors++
+	h.consecutiveFailures++
+	h.lastError = err.Error()
+	// pin yang tidak cocok bukan gangguan sementara, resolver langsung ejected
+	if !h.ejected && h.consecutiveFailures < opts.FailureThreshold && !errors.Is(err, ErrPinMismatch) {
+		return false, 0
+	}
+
+	// Resolver yang ejected dan gagal lagi saat probe menunggu dua kali lebih lama
+	backoff := opts.BaseBackoff << h.ejections
+	if backoff > opts.MaxBackoff || backoff <= 0 {
+		backoff = opts.MaxBackoff
+	} else {
+		h.ejections++
+	}
+	h.ejected = true
+	h.retryAt = time.Now().Add(backoff)
+	return false, backoff
+}
+
+// averageLatency mengembalikan rata-rata latency (EWMA); 0 jika belum ada sampel
+func (h *resolverHealth) averageLatency() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latency
+}
+
+// latencyP90 mengembalikan persentil ke-90 dari sampel latency terakhir
+func (h *resolverHealth) latencyP90() (time.Duration, bool) {
+	h.mu.Lock()
+	n := min(h.sampleCount, latencySamples)
+	samples := make([]time.Duration, n)
+	copy(samples, h.samples[:n])
+	h.mu.Unlock()
+
+	if n == 0 {
+		return 0, false
+	}
+	slices.Sort(samples)
+	return samples[(n*9-1)/10], true
+}
+
+// dueForProbe bernilai true jika resolver ejected dan masa backoff-nya habis.
+// retryAt dimundurkan agar probe yang sama tidak dijalankan dua kali
+func (h *resolverHealth) dueForProbe(now time.Time, opts HealthOptions) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.ejected || now.Before(h.retryAt) {
+		return false
+	}
+	h.retryAt = now.Add(opts.MaxBackoff)
+	return true
+}
+
+func (h *resolverHealth) stats(resolver Resolver) ResolverStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := ResolverStats{
+		ID:                  resolver.ID,
+		URL:                 resolver.URL,
+		Healthy:             !h.ejected,
+		Successes:           h.successes,
+		Errors:              h.errors,
+		ConsecutiveFailures: h.consecutiveFailures,
+		LatencyMs:           float64(h.latency) / float64(time.Millisecond),
+		LastError:           h.lastError,
+	}
+	if h.ejected {
+		retryAt := h.retryAt
+		stats.RetryAt = &retryAt
+	}
+	return stats
+}
+
+// recordResult mencatat hasil query ke resolver ke-i dan menulis log jika
+// status kesehatannya berubah
+func (d *Pool) recordResult(i int, latency time.Duration, err error) {
+	restored, ejectedFor := d.health[i].record(latency, err, d.healthOpts)
+	if restored {
+		log.Printf("[INFO] Resolver [%s] is healthy again", d.Resolvers[i].ID)
+	}
+	if ejectedFor > 0 {
+		log.Printf("[WARN] Resolver [%s] ejected for %s: %v", d.Resolvers[i].ID, ejectedFor, err)
+	}
+}
+
+// Stats mengembalikan statistik dan status kesehatan semua resolver
+func (d *Pool) Stats() []ResolverStats {
+	stats := make([]ResolverStats, len(d.Resolvers))
+	for i, resolver := range d.Resolvers {
+		stats[i] = d.health[i].stats(resolver)
+	}
+	return stats
+}
+
+// probe mengirim query uji ke resolver ke-i. Hanya jawaban NOERROR/NXDOMAIN
+// yang dianggap sehat
+func (d *Pool) probe(i int) {
+	req := new(dns.Msg)
+	req.SetQuestion(d.healthOpts.ProbeName, dns.TypeA)
+
+	// IP loopback agar probe tidak mengirim EDNS Client Subnet
+	resp, latency, err := d.exchange(i, req, "127.0.0.1")
+	d.recordResult(i, latency, resultErr(resp, err))
+}
+
+// resultErr mengembalikan err, atau error jika jawaban bukan final (SERVFAIL,
+// REFUSED, ...), agar resolver yang selalu menjawab gagal tetap ejected
+func resultErr(resp *DOHResponse, err error) error {
+	if err == nil && !resp.isFinal() {
+		return fmt.Errorf("answered %s", dns.RcodeToString[resp.Status])
+	}
+	return err
+}
+
+// StartHealthCheck menjalankan probe berkala ke resolver yang ejected dan
+// memulihkannya begitu probe berhasil
+func (d *Pool) StartHealthCheck(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			now := time.Now()
+			for i := range d.Resolvers {
+				if d.health[i].dueForProbe(now, d.healthOpts) {
+					go d.probe(i)
+				}
+			}
+		}
+	}()
+}
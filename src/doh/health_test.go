@@ -0,0 +1,36 @@
+package doh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServfailResolverEjected(t *testing.T) {
+	for _, strategy := range []string{StrategyWeighted, StrategyParallel} {
+		t.Run(strategy, func(t *testing.T) {
+			servfail := newTestResolver(t, "servfail", 0, dns.RcodeServerFailure)
+			// good menjawab belakangan agar hasil servfail di mode parallel
+			// sudah tercatat sebelum query selesai
+			good := newTestResolver(t, "good", 20*time.Millisecond, dns.RcodeSuccess)
+			good.resolver.Weight = 0 // servfail selalu dipilih lebih dulu selama sehat
+			d := newTestPool(strategy, servfail, good)
+
+			for range defaultFailureThreshold {
+				if info, _ := testQuery(t, d); info.Resolver != "good" {
+					t.Errorf("answer from %s, want good", info.Resolver)
+				}
+			}
+
+			stats := d.Stats()[0]
+			if stats.Healthy || stats.Successes != 0 || stats.Errors != defaultFailureThreshold {
+				t.Fatalf("servfail resolver stats = %+v, want ejected after %d errors", stats, defaultFailureThreshold)
+			}
+			testQuery(t, d)
+			if n := servfail.hits.Load(); n != defaultFailureThreshold {
+				t.Errorf("servfail resolver hits = %d, want %d (no queries once ejected)", n, defaultFailureThreshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package doh
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrPinMismatch dikembalikan jika sertifikat resolver tidak cocok dengan
+// pin SPKI di config, misalnya karena koneksi dibelokkan proxy MITM.
+// Resolver langsung dianggap tidak sehat tanpa menunggu FailureThreshold
+var ErrPinMismatch = errors.New("certificate pin mismatch")
+
+// tlsConfig membuat tls.Config untuk resolver dengan CA bundle dan pin SPKI
+// dari config. serverName tetap hostname dari URL walau alamatnya dari bootstrap
+func tlsConfig(resolver Resolver, serverName string, minVersion uint16) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: minVersion,
+	}
+
+	if resolver.CA != "" {
+		pem, err := os.ReadFile(resolver.CA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", resolver.CA)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(resolver.Pins) > 0 {
+		pins := make(map[string]bool, len(resolver.Pins))
+		for _, pin := range resolver.Pins {
+			pin = strings.TrimPrefix(pin, "sha256/")
+			hash, err := base64.StdEncoding.DecodeString(pin)
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %q: must be a base64 SHA-256 of the SPKI", pin)
+			}
+			pins[string(hash)] = true
+		}
+		config.VerifyPeerCertificate = verifyPins(pins)
+	}
+	return config, nil
+}
+
+// verifyPins memeriksa bahwa salah satu sertifikat pada rantai yang sudah
+// terverifikasi (leaf, intermediate atau root) punya SPKI yang di-pin
+func verifyPins(pins map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(hash[:])] {
+					return nil
+				}
+			}
+		}
+
+		var got string
+		if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+			hash := sha256.Sum256(verifiedChains[0][0].RawSubjectPublicKeyInfo)
+			got = base64.StdEncoding.EncodeToString(hash[:])
+		}
+		return fmt.Errorf("%w: server presented sha256/%s", ErrPinMismatch, got)
+	}
+}
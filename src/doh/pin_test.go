@@ -0,0 +1,47 @@
+package doh
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTLSConfigPins(t *testing.T) {
+	// base64 boleh diawali "/"; cari SPKI yang hash-nya begitu
+	var spki []byte
+	var pin string
+	for i := 0; !strings.HasPrefix(pin, "/"); i++ {
+		spki = []byte(fmt.Sprintf("spki-%d", i))
+		hash := sha256.Sum256(spki)
+		pin = base64.StdEncoding.EncodeToString(hash[:])
+	}
+	chains := [][]*x509.Certificate{{{RawSubjectPublicKeyInfo: spki}}}
+
+	for _, configured := range []string{pin, "sha256/" + pin} {
+		t.Run(configured, func(t *testing.T) {
+			config, err := tlsConfig(Resolver{Pins: []string{configured}}, "resolver.example", 0)
+			if err != nil {
+				t.Fatalf("tlsConfig() error = %v", err)
+			}
+			if err := config.VerifyPeerCertificate(nil, chains); err != nil {
+				t.Errorf("VerifyPeerCertificate() error = %v", err)
+			}
+		})
+	}
+
+	other := sha256.Sum256([]byte("other"))
+	config, err := tlsConfig(Resolver{Pins: []string{base64.StdEncoding.EncodeToString(other[:])}}, "resolver.example", 0)
+	if err != nil {
+		t.Fatalf("tlsConfig() error = %v", err)
+	}
+	if err := config.VerifyPeerCertificate(nil, chains); err == nil {
+		t.Error("VerifyPeerCertificate() accepted a chain without the pinned SPKI")
+	}
+
+	if _, err := tlsConfig(Resolver{Pins: []string{"sha256/not-base64"}}, "resolver.example", 0); err == nil {
+		t.Error("tlsConfig() accepted an invalid pin")
+	}
+}
@@ -0,0 +1,57 @@
+package doh
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/miekg/dns"
+)
+
+// udpUpstream adalah server DNS biasa lewat UDP (RFC 1035), misal DNS LAN
+// atau unbound lokal. Response yang terpotong (bit TC) diulang lewat TCP.
+// EDNS Client Subnet tidak ditambahkan karena server-nya di jaringan sendiri
+type udpUpstream struct {
+	resolver Resolver
+	addr     string
+	boot     *bootstrap // nil jika host di URL sudah berupa IP atau diserahkan ke resolver sistem
+	client   *dns.Client
+	tcp      *streamUpstream
+}
+
+func newUDPUpstream(resolver Resolver, addr string, boot *bootstrap) *udpUpstream {
+	return &udpUpstream{
+		resolver: resolver,
+		addr:     addr,
+		boot:     boot,
+		client:   &dns.Client{Net: "udp", Timeout: upstreamTimeout},
+		tcp:      newStreamUpstream(resolver, "tcp", addr, nil, boot),
+	}
+}
+
+func (u *udpUpstream) Exchange(req *dns.Msg, clientIP string) (*DOHResponse, error) {
+	query := req.Copy()
+	query.Id = dns.Id()
+
+	addr := u.addr
+	if u.boot != nil {
+		var err error
+		if addr, err = u.boot.resolveAddr(addr); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[DEBUG] Querying resolver [%s]: udp %s", u.resolver.ID, addr)
+	reply, _, err := u.client.Exchange(query, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if reply.Truncated {
+		return u.tcp.Exchange(req, clientIP)
+	}
+
+	packed, err := reply.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack response: %w", err)
+	}
+	return wireResponse(query, reply, packed)
+}
@@ -0,0 +1,136 @@
+package doh
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Strategi pemilihan resolver upstream
+const (
+	StrategyWeighted   = "weighted"    // weighted random (default)
+	StrategyRoundRobin = "round_robin" // bergiliran
+	StrategyLatency    = "latency"     // rata-rata latency (EWMA) terendah
+	StrategyParallel   = "parallel"    // query ke semua resolver, jawaban pertama menang
+	StrategyHedged     = "hedged"      // resolver berikutnya ditanya jika yang pertama lebih lambat dari p90-nya
+)
+
+// defaultHedgeDelay dipakai strategi hedged selama resolver belum punya sampel latency
+const defaultHedgeDelay = 100 * time.Millisecond
+
+// pickRoundRobin memilih resolver secara bergiliran
+func (d *Pool) pickRoundRobin(candidates []int) int {
+	next := d.rrNext.Add(1) - 1
+	return candidates[int(next%uint32(len(candidates)))]
+}
+
+// pickLowestLatency memilih resolver dengan rata-rata latency terendah.
+// Resolver yang belum punya sampel dipilih lebih dulu agar latency-nya terukur
+func (d *Pool) pickLowestLatency(candidates []int) int {
+	best := candidates[0]
+	bestLatency := d.health[best].averageLatency()
+	for _, i := range candidates[1:] {
+		if latency := d.health[i].averageLatency(); latency < bestLatency {
+			best, bestLatency = i, latency
+		}
+	}
+	return best
+}
+
+// hedgeDelay adalah waktu tunggu sebelum resolver berikutnya ikut ditanya:
+// p90 latency resolver yang sedang ditunggu
+func (d *Pool) hedgeDelay(i int) time.Duration {
+	if p90, ok := d.health[i].latencyP90(); ok {
+		return p90
+	}
+	return defaultHedgeDelay
+}
+
+type attempt struct {
+	index   int
+	dohResp *DOHResponse
+	err     error
+}
+
+// race mengirim query ke beberapa resolver sekaligus dan mengembalikan jawaban
+// final pertama. Strategi parallel langsung menanyai semua resolver; strategi
+// hedged menambah satu resolver tiap kali hedge delay lewat atau ada yang gagal
+func (d *Pool) race(req *dns.Msg, clientIP string) (*DOHResponse, ResolverInfo, error) {
+	domain := strings.TrimSuffix(req.Question[0].Name, ".")
+
+	var order []int
+	if d.strategy == StrategyParallel {
+		order = d.candidates(nil)
+	} else {
+		// urutan hedging mengikuti weighted random, resolver sehat lebih dulu
+		tried := make(map[int]bool, len(d.Resolvers))
+		for len(order) < len(d.Resolvers) {
+			i := d.pickWeighted(d.candidates(tried))
+			tried[i] = true
+			order = append(order, i)
+		}
+	}
+
+	// buffer seukuran order agar goroutine yang kalah tidak tertahan
+	results := make(chan attempt, len(order))
+	launched, received := 0, 0
+	var hedge <-chan time.Time
+	launch := func() {
+		i := order[launched]
+		launched++
+		go func() {
+			dohResp, latency, err := d.exchange(i, req, clientIP)
+			d.recordResult(i, latency, resultErr(dohResp, err))
+			results <- attempt{index: i, dohResp: dohResp, err: err}
+		}()
+
+		hedge = nil
+		if d.strategy == StrategyHedged && launched < len(order) {
+			hedge = time.After(d.hedgeDelay(i))
+		}
+	}
+
+	launch()
+	for d.strategy == StrategyParallel && launched < len(order) {
+		launch()
+	}
+
+	var lastResp *DOHResponse
+	var lastInfo ResolverInfo
+	for received < launched {
+		select {
+		case <-hedge:
+			log.Printf("[DEBUG] Resolver [%s] slower than its p90 for %s, hedging", d.Resolvers[order[launched-1]].ID, domain)
+			launch()
+		case a := <-results:
+			received++
+			resolver := d.Resolvers[a.index]
+			resolverInfo := ResolverInfo{
+				Resolver:    resolver.ID,
+				ResolverURL: resolver.URL,
+			}
+
+			if a.err != nil {
+				log.Printf("[ERROR] Resolver [%s] failed: %v", resolver.ID, a.err)
+			} else if a.dohResp.isFinal() {
+				return a.dohResp, resolverInfo, nil
+			} else {
+				log.Printf("[WARN] Resolver [%s] answered %s for %s, waiting for other resolvers", resolver.ID, dns.RcodeToString[a.dohResp.Status], domain)
+				lastResp, lastInfo = a.dohResp, resolverInfo
+			}
+
+			// hedged: jika semua yang ditanya gagal, resolver berikutnya langsung ditanya
+			if received == launched && launched < len(order) {
+				launch()
+			}
+		}
+	}
+
+	if lastResp != nil {
+		return lastResp, lastInfo, nil
+	}
+	return nil, ResolverInfo{}, fmt.Errorf("all resolvers failed for domain: %s", domain)
+}
@@ -0,0 +1,225 @@
+package doh
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testResolver adalah resolver DoH JSON lokal dengan delay dan rcode tertentu
+type testResolver struct {
+	srv      *httptest.Server
+	resolver Resolver
+	hits     atomic.Int32
+	firstHit atomic.Int64 // UnixNano request pertama
+}
+
+// newTestResolver menjalankan resolver yang menjawab setelah delay. status < 0
+// berarti resolver gagal (HTTP 500, bukan JSON)
+func newTestResolver(t *testing.T, id string, delay time.Duration, status int) *testResolver {
+	t.Helper()
+	r := &testResolver{}
+	r.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.firstHit.CompareAndSwap(0, time.Now().UnixNano())
+		r.hits.Add(1)
+		time.Sleep(delay)
+		if status < 0 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-json")
+		fmt.Fprintf(w, `{"Status":%d,"Answer":[{"name":"example.com.","type":1,"TTL":60,"data":"192.0.2.1"}]}`, status)
+	}))
+	t.Cleanup(r.srv.Close)
+
+	ca := filepath.Join(t.TempDir(), id+".pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: r.srv.Certificate().Raw}
+	if err := os.WriteFile(ca, pem.EncodeToMemory(block), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.resolver = Resolver{ID: id, URL: r.srv.URL + "/resolve", Weight: 1, CA: ca}
+	return r
+}
+
+func newTestPool(strategy string, resolvers ...*testResolver) *Pool {
+	list := make([]Resolver, len(resolvers))
+	for i, r := range resolvers {
+		list[i] = r.resolver
+	}
+	return NewPoolWithOptions(list, Options{Strategy: strategy})
+}
+
+func testQuery(t *testing.T, d *Pool) (ResolverInfo, time.Duration) {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	start := time.Now()
+	dohResp, info, err := d.Query(req, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if dohResp.Status != dns.RcodeSuccess {
+		t.Fatalf("Query() status = %s, want NOERROR", dns.RcodeToString[dohResp.Status])
+	}
+	return info, time.Since(start)
+}
+
+func TestStrategyWeighted(t *testing.T) {
+	t.Run("weight 0 never picked while others are healthy", func(t *testing.T) {
+		a := newTestResolver(t, "a", 0, dns.RcodeSuccess)
+		b := newTestResolver(t, "b", 0, dns.RcodeSuccess)
+		spare := newTestResolver(t, "spare", 0, dns.RcodeSuccess)
+		a.resolver.Weight, b.resolver.Weight, spare.resolver.Weight = 3, 1, 0
+		d := newTestPool(StrategyWeighted, a, b, spare)
+
+		for range 40 {
+			testQuery(t, d)
+		}
+		if n := spare.hits.Load(); n != 0 {
+			t.Errorf("weight 0 resolver hits = %d, want 0", n)
+		}
+		if a.hits.Load() == 0 || b.hits.Load() == 0 {
+			t.Errorf("hits a=%d b=%d, want both positive-weight resolvers used", a.hits.Load(), b.hits.Load())
+		}
+	})
+
+	t.Run("failover on HTTP 500", func(t *testing.T) {
+		failing := newTestResolver(t, "failing", 0, -1)
+		backup := newTestResolver(t, "backup", 0, dns.RcodeSuccess)
+		backup.resolver.Weight = 0 // failing selalu dicoba lebih dulu
+		d := newTestPool(StrategyWeighted, failing, backup)
+
+		info, _ := testQuery(t, d)
+		if info.Resolver != "backup" {
+			t.Errorf("answer from %s, want backup", info.Resolver)
+		}
+		if failing.hits.Load() != 1 || backup.hits.Load() != 1 {
+			t.Errorf("hits failing=%d backup=%d, want 1 and 1", failing.hits.Load(), backup.hits.Load())
+		}
+	})
+}
+
+func TestStrategyRoundRobin(t *testing.T) {
+	a := newTestResolver(t, "a", 0, dns.RcodeSuccess)
+	b := newTestResolver(t, "b", 0, dns.RcodeSuccess)
+	c := newTestResolver(t, "c", 0, dns.RcodeSuccess)
+	d := newTestPool(StrategyRoundRobin, a, b, c)
+
+	var got []string
+	for range 6 {
+		info, _ := testQuery(t, d)
+		got = append(got, info.Resolver)
+	}
+	if fmt.Sprint(got) != "[a b c a b c]" {
+		t.Errorf("round robin order = %v, want [a b c a b c]", got)
+	}
+}
+
+func TestStrategyLatency(t *testing.T) {
+	slow := newTestResolver(t, "slow", 100*time.Millisecond, dns.RcodeSuccess)
+	fast := newTestResolver(t, "fast", 0, dns.RcodeSuccess)
+	d := newTestPool(StrategyLatency, slow, fast)
+
+	// resolver tanpa sampel dipilih lebih dulu, lalu yang EWMA-nya terendah
+	var got []string
+	for range 5 {
+		info, _ := testQuery(t, d)
+		got = append(got, info.Resolver)
+	}
+	if fmt.Sprint(got) != "[slow fast fast fast fast]" {
+		t.Errorf("latency picks = %v, want [slow fast fast fast fast]", got)
+	}
+
+	// EWMA mengikuti latency terbaru: resolver yang melambat ditinggalkan
+	for range 10 {
+		d.recordResult(1, 200*time.Millisecond, nil)
+	}
+	if i := d.pickLowestLatency([]int{0, 1}); i != 0 {
+		t.Errorf("pickLowestLatency() = %d after fast resolver slowed down, want 0", i)
+	}
+}
+
+func TestStrategyParallel(t *testing.T) {
+	failing := newTestResolver(t, "failing", 0, -1)
+	servfail := newTestResolver(t, "servfail", 0, dns.RcodeServerFailure)
+	good := newTestResolver(t, "good", 50*time.Millisecond, dns.RcodeSuccess)
+	slower := newTestResolver(t, "slower", 500*time.Millisecond, dns.RcodeSuccess)
+	d := newTestPool(StrategyParallel, failing, servfail, good, slower)
+
+	info, elapsed := testQuery(t, d)
+	if info.Resolver != "good" {
+		t.Errorf("parallel answer from %s, want good", info.Resolver)
+	}
+	if elapsed >= 500*time.Millisecond {
+		t.Errorf("parallel query took %v, waited for the slower resolver", elapsed)
+	}
+	for _, r := range []*testResolver{failing, servfail, good, slower} {
+		if r.hits.Load() != 1 {
+			t.Errorf("resolver %s hits = %d, want 1", r.resolver.ID, r.hits.Load())
+		}
+	}
+}
+
+func TestStrategyHedged(t *testing.T) {
+	const p90 = 80 * time.Millisecond
+
+	t.Run("hedge after p90", func(t *testing.T) {
+		slow := newTestResolver(t, "slow", 500*time.Millisecond, dns.RcodeSuccess)
+		backup := newTestResolver(t, "backup", 0, dns.RcodeSuccess)
+		slow.resolver.Weight, backup.resolver.Weight = 1, 0 // urutan hedging: slow lalu backup
+		d := newTestPool(StrategyHedged, slow, backup)
+		for range 10 {
+			d.recordResult(0, p90, nil)
+		}
+		if delay := d.hedgeDelay(0); delay != p90 {
+			t.Fatalf("hedgeDelay() = %v, want p90 %v", delay, p90)
+		}
+
+		start := time.Now()
+		info, elapsed := testQuery(t, d)
+		if info.Resolver != "backup" {
+			t.Errorf("hedged answer from %s, want backup", info.Resolver)
+		}
+		if hedgedAfter := time.Duration(backup.firstHit.Load() - start.UnixNano()); hedgedAfter < p90 {
+			t.Errorf("hedge fired after %v, before p90 %v", hedgedAfter, p90)
+		}
+		if elapsed >= 500*time.Millisecond {
+			t.Errorf("hedged query took %v, waited for the slow resolver", elapsed)
+		}
+	})
+
+	t.Run("no hedge within p90", func(t *testing.T) {
+		fast := newTestResolver(t, "fast", 0, dns.RcodeSuccess)
+		backup := newTestResolver(t, "backup", 0, dns.RcodeSuccess)
+		fast.resolver.Weight, backup.resolver.Weight = 1, 0
+		d := newTestPool(StrategyHedged, fast, backup)
+		for range 10 {
+			d.recordResult(0, time.Second, nil)
+		}
+
+		for range 3 {
+			if info, _ := testQuery(t, d); info.Resolver != "fast" {
+				t.Errorf("hedged answer from %s, want fast", info.Resolver)
+			}
+		}
+		if n := backup.hits.Load(); n != 0 {
+			t.Errorf("backup resolver hits = %d, want 0", n)
+		}
+	})
+
+	t.Run("default delay without samples", func(t *testing.T) {
+		r := newTestResolver(t, "r", 0, dns.RcodeSuccess)
+		d := newTestPool(StrategyHedged, r)
+		if delay := d.hedgeDelay(0); delay != defaultHedgeDelay {
+			t.Errorf("hedgeDelay() = %v, want %v", delay, defaultHedgeDelay)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package doh
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// streamIdleTimeout adalah lama koneksi TCP/TLS upstream dibiarkan terbuka tanpa query
+const streamIdleTimeout = 30 * time.Second
+
+var errStreamClosed = errors.New("upstream connection closed")
+
+// streamUpstream adalah server DNS lewat TCP atau TLS (DoT, RFC 7858). Satu
+// koneksi dipakai ulang untuk banyak query sekaligus (pipelining, RFC 7766);
+// response dicocokkan dengan query-nya lewat message ID
+type streamUpstream struct {
+	resolver  Resolver
+	network   string // "tcp" atau "tcp-tls"
+	addr      string
+	boot      *bootstrap
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	conn *streamConn
+}
+
+// streamConn adalah satu koneksi aktif beserta query yang menunggu response
+type streamConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan *dns.Msg
+	closed  bool
+}
+
+// tlsConfig wajib diisi untuk network "tcp-tls" dan nil untuk "tcp"
+func newStreamUpstream(resolver Resolver, network string, addr string, tlsConfig *tls.Config, boot *bootstrap) *streamUpstream {
+	return &streamUpstream{
+		resolver:  resolver,
+		network:   network,
+		addr:      addr,
+		boot:      boot,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (u *streamUpstream) Exchange(req *dns.Msg, clientIP string) (*DOHResponse, error) {
+	query := req.Copy()
+	if u.network == "tcp-tls" {
+		ip := net.ParseIP(clientIP)
+		if ip != nil && !isPrivateIP(ip) {
+			addClientSubnet(query, ip, 24)
+		}
+	}
+
+	log.Printf("[DEBUG] Querying resolver [%s]: %s %s", u.resolver.ID, u.network, u.addr)
+	// koneksi yang dipakai ulang bisa saja baru ditutup server karena idle;
+	// dalam kasus itu query diulang sekali lewat koneksi baru
+	var reply *dns.Msg
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		sc, connErr := u.connection()
+		if connErr != nil {
+			return nil, fmt.Errorf("failed to connect: %w", connErr)
+		}
+		reply, err = sc.exchange(query)
+		if !errors.Is(err, errStreamClosed) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	packed, err := reply.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack response: %w", err)
+	}
+	return wireResponse(query, reply, packed)
+}
+
+// connection mengembalikan koneksi yang masih terbuka atau membuka yang baru
+func (u *streamUpstream) connection() (*streamConn, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.conn != nil && !u.conn.isClosed() {
+		return u.conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if u.boot != nil {
+		conn, err = u.boot.DialContext(ctx, "tcp", u.addr)
+	} else {
+		dialer := &net.Dialer{Timeout: upstreamTimeout}
+		conn, err = dialer.DialContext(ctx, "tcp", u.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.tlsConfig != nil {
+		// ServerName tetap hostname dari URL walau koneksi dibuka ke IP bootstrap
+		tlsConn := tls.Client(conn, u.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	u.conn = &streamConn{
+		conn:    conn,
+		pending: make(map[uint16]chan *dns.Msg),
+	}
+	go u.conn.readLoop()
+	return u.conn, nil
+}
+
+func (c *streamConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// exchange mengirim query dengan message ID yang unik di koneksi ini lalu
+// menunggu response-nya, sementara query lain bisa berjalan bersamaan
+func (c *streamConn) exchange(query *dns.Msg) (*dns.Msg, error) {
+	replyCh := make(chan *dns.Msg, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errStreamClosed
+	}
+	query.Id = dns.Id()
+	for c.pending[query.Id] != nil {
+		query.Id = dns.Id()
+	}
+	c.pending[query.Id] = replyCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, query.Id)
+		c.mu.Unlock()
+	}()
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+	frame := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(frame, uint16(len(packed)))
+	copy(frame[2:], packed)
+
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(upstreamTimeout))
+	_, err = c.conn.Write(frame)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("%w: %v", errStreamClosed, err)
+	}
+
+	select {
+	case reply, ok := <-replyCh:
+		if !ok {
+			return nil, errStreamClosed
+		}
+		return reply, nil
+	case <-time.After(upstreamTimeout):
+		return nil, fmt.Errorf("timeout waiting for response")
+	}
+}
+
+// readLoop membaca response dari koneksi dan meneruskannya ke query yang
+// menunggu. Koneksi ditutup jika idle terlalu lama atau terjadi error
+func (c *streamConn) readLoop() {
+	defer c.close()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+
+		var length uint16
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[DEBUG] Closing upstream connection to %s: %v", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			return
+		}
+
+		reply := new(dns.Msg)
+		if err := reply.Unpack(packet); err != nil {
+			log.Printf("[ERROR] Failed to parse upstream response from %s: %v", c.conn.RemoteAddr(), err)
+			continue
+		}
+
+		c.mu.Lock()
+		replyCh := c.pending[reply.Id]
+		delete(c.pending, reply.Id)
+		c.mu.Unlock()
+		if replyCh != nil {
+			replyCh <- reply
+		}
+	}
+}
+
+// close menutup koneksi dan menggagalkan semua query yang masih menunggu
+func (c *streamConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.conn.Close()
+	for id, replyCh := range c.pending {
+		close(replyCh)
+		delete(c.pending, id)
+	}
+}
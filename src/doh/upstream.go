@@ -0,0 +1,76 @@
+package doh
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamTimeout adalah batas waktu satu query ke resolver upstream
+const upstreamTimeout = 5 * time.Second
+
+// Upstream adalah satu server DNS upstream. Response dikembalikan sebagai
+// DOHResponse agar cache dan BuildResp sama untuk semua protokol
+type Upstream interface {
+	Exchange(req *dns.Msg, clientIP string) (*DOHResponse, error)
+}
+
+// newUpstream membuat Upstream sesuai skema URL resolver:
+// https:// (DoH), tls:// (DoT, RFC 7858), tcp:// dan udp:// (DNS biasa)
+func newUpstream(resolver Resolver) (Upstream, error) {
+	u, err := url.Parse(resolver.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver URL: %w", err)
+	}
+	boot, err := newBootstrap(resolver.Bootstrap)
+	if err != nil {
+		return nil, err
+	}
+
+	secure := u.Scheme == "https" || u.Scheme == "tls"
+	if !secure && (len(resolver.Pins) > 0 || resolver.CA != "") {
+		return nil, fmt.Errorf("pins and ca are only supported for https:// and tls:// resolvers")
+	}
+
+	switch u.Scheme {
+	case "https", "http":
+		config, err := tlsConfig(resolver, "", tls.VersionTLS13)
+		if err != nil {
+			return nil, err
+		}
+		return newDOHUpstream(resolver, config, boot), nil
+	case "tls":
+		config, err := tlsConfig(resolver, u.Hostname(), tls.VersionTLS12)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamUpstream(resolver, "tcp-tls", hostPort(u, "853"), config, boot), nil
+	case "tcp":
+		return newStreamUpstream(resolver, "tcp", hostPort(u, "53"), nil, boot), nil
+	case "udp":
+		return newUDPUpstream(resolver, hostPort(u, "53"), boot), nil
+	default:
+		return nil, fmt.Errorf("unsupported resolver URL scheme %q", u.Scheme)
+	}
+}
+
+// hostPort mengambil host:port dari URL, dengan port default jika tidak ada
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u.Host
+}
+
+// brokenUpstream dipakai untuk resolver yang konfigurasinya tidak valid
+type brokenUpstream struct {
+	err error
+}
+
+func (u brokenUpstream) Exchange(req *dns.Msg, clientIP string) (*DOHResponse, error) {
+	return nil, u.err
+}
@@ -0,0 +1,122 @@
+package doh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const dnsMessageContentType = "application/dns-message"
+
+// queryWire mengirim dns.Msg milik client apa adanya (RFC 8484), sehingga
+// EDNS, record DNSSEC, section additional dan semua tipe RR tetap utuh
+func (u *dohUpstream) queryWire(req *dns.Msg, clientIP string) (*DOHResponse, error) {
+	resolver := u.resolver
+	query := req.Copy()
+	query.Id = 0 // RFC 8484: ID 0 agar response GET bisa di-cache HTTP
+
+	ip := net.ParseIP(clientIP)
+	if ip != nil && !isPrivateIP(ip) {
+		addClientSubnet(query, ip, 24)
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	var httpReq *http.Request
+	if strings.EqualFold(resolver.Method, http.MethodPost) {
+		log.Printf("[DEBUG] Querying resolver [%s]: POST %s", resolver.ID, resolver.URL)
+		httpReq, err = http.NewRequest(http.MethodPost, resolver.URL, bytes.NewReader(packed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", dnsMessageContentType)
+	} else {
+		sep := "?"
+		if strings.Contains(resolver.URL, "?") {
+			sep = "&"
+		}
+		url := resolver.URL + sep + "dns=" + base64.RawURLEncoding.EncodeToString(packed)
+		log.Printf("[DEBUG] Querying resolver [%s]: %s", resolver.ID, url)
+		httpReq, err = http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+	}
+	httpReq.Header.Set("Accept", dnsMessageContentType)
+
+	resp, err := u.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to parse wire response: %w", err)
+	}
+	return wireResponse(query, reply, body)
+}
+
+// wireResponse membungkus response wire-format menjadi DOHResponse setelah
+// memastikan question-nya sesuai dengan query
+func wireResponse(query *dns.Msg, reply *dns.Msg, packed []byte) (*DOHResponse, error) {
+	if len(reply.Question) == 0 || !strings.EqualFold(reply.Question[0].Name, query.Question[0].Name) {
+		return nil, fmt.Errorf("response question does not match query")
+	}
+
+	return &DOHResponse{
+		Status: reply.Rcode,
+		TC:     reply.Truncated,
+		RD:     reply.RecursionDesired,
+		RA:     reply.RecursionAvailable,
+		AD:     reply.AuthenticatedData,
+		CD:     reply.CheckingDisabled,
+		Wire:   packed,
+	}, nil
+}
+
+// addClientSubnet menambahkan opsi EDNS Client Subnet jika query belum memilikinya
+func addClientSubnet(msg *dns.Msg, ip net.IP, prefixLen int) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0SUBNET {
+			return
+		}
+	}
+
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(prefixLen),
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		subnet.Family = 1
+		subnet.Address = ip4.Mask(net.CIDRMask(prefixLen, 32))
+	} else {
+		subnet.Family = 2
+		subnet.Address = ip.Mask(net.CIDRMask(prefixLen, 128))
+	}
+	opt.Option = append(opt.Option, subnet)
+}
@@ -0,0 +1,181 @@
+package filter
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Jawaban untuk query yang diblokir. Selain nilai di bawah, mode boleh
+// berupa alamat IP sinkhole, misal "192.168.1.10"
+const (
+	ModeNXDomain = "nxdomain" // NXDOMAIN (default)
+	ModeRefused  = "refused"  // REFUSED
+	ModeNull     = "null_ip"  // 0.0.0.0 untuk A, :: untuk AAAA
+	ModeNoData   = "nodata"   // NOERROR tanpa jawaban
+)
+
+const (
+	// fetchTimeout adalah batas waktu mengunduh satu list
+	fetchTimeout = 30 * time.Second
+
+	// failedRetryInterval adalah jeda refresh selama ada list yang belum
+	// pernah berhasil dimuat, misal karena jaringan belum siap saat start
+	failedRetryInterval = time.Minute
+)
+
+// List adalah satu sumber daftar blokir: file lokal (Path) atau HTTP (URL).
+// Format hosts, daftar domain dan Adblock boleh dicampur dalam satu list
+type List struct {
+	Name     string
+	Path     string
+	URL      string
+	Mode     string // kosong = mode global
+	Filtered bool   // list dipasang atas permintaan client (misal parental control): EDE Filtered, bukan Blocked
+	Allow    bool   // allowlist: semua rule di list ini menjadi rule allow
+}
+
+// Match berisi rule yang cocok dengan query
+type Match struct {
+	List     string // nama list
+	Rule     string // baris rule asli
+	Allow    bool   // rule allow; query tidak diblokir
+	Mode     string // mode list, kosong = mode global
+	Filtered bool
+}
+
+// ValidMode bernilai true jika mode dikenal atau berupa alamat IP
+func ValidMode(mode string) bool {
+	switch mode {
+	case ModeNXDomain, ModeRefused, ModeNull, ModeNoData:
+		return true
+	}
+	return net.ParseIP(mode) != nil
+}
+
+// Filter mencocokkan nama domain dengan semua list. Refresh mem-parse list
+// di background lalu mengganti matcher sekaligus (atomic), sehingga query
+// tidak pernah melihat list yang baru setengah dimuat
+type Filter struct {
+	sources []*source
+	matcher atomic.Pointer[matcher]
+	client  *http.Client
+
+	refreshMu sync.Mutex
+}
+
+// New memuat dan mengompilasi semua list. File yang gagal dibaca menghasilkan
+// error agar salah path di config langsung terlihat; list HTTP yang gagal
+// diunduh hanya dicatat dan dicoba lagi oleh refresh
+func New(lists []List) (*Filter, error) {
+	f := &Filter{
+		client: &http.Client{Timeout: fetchTimeout},
+	}
+	for _, list := range lists {
+		if list.Mode != "" && !ValidMode(list.Mode) {
+			return nil, fmt.Errorf("invalid mode %q for list %s", list.Mode, list.Name)
+		}
+		if (list.Path == "") == (list.URL == "") {
+			return nil, fmt.Errorf("list %s must have either path or url", list.Name)
+		}
+		f.sources = append(f.sources, &source{list: list})
+	}
+
+	for _, s := range f.sources {
+		if _, err := s.update(f.client); err != nil {
+			if s.list.URL == "" {
+				return nil, fmt.Errorf("failed to load list %s: %w", s.list.Name, err)
+			}
+			log.Printf("[WARN] Failed to download list %s, retrying later: %v", s.list.Name, err)
+			continue
+		}
+		s.logLoaded()
+	}
+	f.compile()
+	return f, nil
+}
+
+// Match mengembalikan rule yang cocok dengan name, baik allow maupun block;
+// urutan pencocokan dijelaskan di matcher.match
+func (f *Filter) Match(name string) (Match, bool) {
+	return f.matcher.Load().match(name)
+}
+
+// Refresh memeriksa semua list dan mengompilasi ulang matcher jika ada yang
+// berubah. List yang gagal diperbarui tetap memakai rule lamanya
+func (f *Filter) Refresh() {
+	f.refreshMu.Lock()
+	defer f.refreshMu.Unlock()
+
+	changed := false
+	for _, s := range f.sources {
+		updated, err := s.update(f.client)
+		if err != nil {
+			log.Printf("[WARN] Failed to refresh list %s: %v", s.list.Name, err)
+			continue
+		}
+		if updated {
+			s.logLoaded()
+			changed = true
+		}
+	}
+	if changed {
+		f.compile()
+	}
+}
+
+// StartRefreshLoop menjalankan Refresh secara berkala
+func (f *Filter) StartRefreshLoop(interval time.Duration) {
+	go func() {
+		for {
+			wait := interval
+			if f.hasUnloaded() {
+				wait = min(interval, failedRetryInterval)
+			}
+			time.Sleep(wait)
+			f.Refresh()
+		}
+	}()
+}
+
+// Stats mengembalikan jumlah rule dan status update tiap list
+func (f *Filter) Stats() []ListStats {
+	stats := make([]ListStats, len(f.sources))
+	for i, s := range f.sources {
+		stats[i] = s.stats()
+	}
+	return stats
+}
+
+// compile membuat matcher baru dari rule terakhir semua list lalu
+// menggantikan matcher lama
+func (f *Filter) compile() {
+	m := newMatcher()
+	total := 0
+	for _, s := range f.sources {
+		s.mu.Lock()
+		for _, r := range s.rules {
+			m.add(&s.list, r)
+		}
+		total += len(s.rules)
+		s.mu.Unlock()
+	}
+	f.matcher.Store(m)
+	log.Printf("[INFO] Blocklists compiled: %d rules from %d lists", total, len(f.sources))
+}
+
+func (f *Filter) hasUnloaded() bool {
+	for _, s := range f.sources {
+		s.mu.Lock()
+		loaded := s.loaded
+		s.mu.Unlock()
+		if !loaded {
+			return true
+		}
+	}
+	return false
+}
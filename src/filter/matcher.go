@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+)
+
+// entry adalah rule yang sudah dikompilasi beserta asal list-nya
+type entry struct {
+	list *List
+	text string
+}
+
+// patternEntry adalah rule glob atau regex yang sudah dikompilasi
+type patternEntry struct {
+	entry
+	pattern *regexp.Regexp
+}
+
+// ruleSet berisi rule allow atau rule block, dikelompokkan per jenis
+type ruleSet struct {
+	exact  map[string]entry
+	suffix map[string]entry
+	globs  []patternEntry
+	regexs []patternEntry
+}
+
+// matcher adalah hasil kompilasi semua list. Rule exact dan suffix disimpan
+// di map per nama, sehingga pencocokan hanya butuh satu lookup per label;
+// glob dan regex dicoba satu per satu. matcher tidak pernah diubah setelah
+// dibuat; refresh membuat matcher baru
+type matcher struct {
+	allow ruleSet
+	block ruleSet
+}
+
+func newMatcher() *matcher {
+	return &matcher{
+		allow: ruleSet{exact: make(map[string]entry), suffix: make(map[string]entry)},
+		block: ruleSet{exact: make(map[string]entry), suffix: make(map[string]entry)},
+	}
+}
+
+// add menyimpan rule; jika nama yang sama muncul di beberapa list, rule
+// pertama yang dicatat. Semua rule di list allowlist menjadi rule allow
+func (m *matcher) add(list *List, r rule) {
+	set := &m.block
+	if r.allow || list.Allow {
+		set = &m.allow
+	}
+
+	e := entry{list: list, text: r.text}
+	switch r.kind {
+	case kindExact:
+		if _, exists := set.exact[r.domain]; !exists {
+			set.exact[r.domain] = e
+		}
+	case kindSuffix:
+		if _, exists := set.suffix[r.domain]; !exists {
+			set.suffix[r.domain] = e
+		}
+	case kindGlob:
+		set.globs = append(set.globs, patternEntry{entry: e, pattern: r.pattern})
+	case kindRegex:
+		set.regexs = append(set.regexs, patternEntry{entry: e, pattern: r.pattern})
+	}
+}
+
+// match mengembalikan rule yang cocok dengan name. Urutan pencocokan:
+//
+//  1. rule allow selalu menang atas rule block
+//  2. di dalam allow maupun block: exact, lalu suffix (yang paling spesifik
+//     lebih dulu), lalu glob, lalu regex
+//  3. untuk jenis yang sama, list yang lebih dulu di config menang
+func (m *matcher) match(name string) (Match, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if e, ok := m.allow.lookup(name); ok {
+		return Match{List: e.list.Name, Rule: e.text, Allow: true}, true
+	}
+	if e, ok := m.block.lookup(name); ok {
+		return Match{List: e.list.Name, Rule: e.text, Mode: e.list.Mode, Filtered: e.list.Filtered}, true
+	}
+	return Match{}, false
+}
+
+func (s *ruleSet) lookup(name string) (entry, bool) {
+	if e, ok := s.exact[name]; ok {
+		return e, true
+	}
+	for parent := name; ; {
+		if e, ok := s.suffix[parent]; ok {
+			return e, true
+		}
+		i := strings.IndexByte(parent, '.')
+		if i < 0 {
+			break
+		}
+		parent = parent[i+1:]
+	}
+	for _, p := range s.globs {
+		if p.pattern.MatchString(name) {
+			return p.entry, true
+		}
+	}
+	for _, p := range s.regexs {
+		if p.pattern.MatchString(name) {
+			return p.entry, true
+		}
+	}
+	return entry{}, false
+}
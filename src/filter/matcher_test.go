@@ -0,0 +1,147 @@
+package filter
+
+import "testing"
+
+// testList adalah satu list beserta baris-barisnya, dikompilasi sesuai urutan config
+type testList struct {
+	list  List
+	lines []string
+}
+
+func compileTest(t *testing.T, lists []testList) *matcher {
+	t.Helper()
+	m := newMatcher()
+	for i := range lists {
+		for _, line := range lists[i].lines {
+			rules, ok := parseLine(line)
+			if !ok {
+				t.Fatalf("parseLine(%q) = not a rule", line)
+			}
+			for _, r := range rules {
+				m.add(&lists[i].list, r)
+			}
+		}
+	}
+	return m
+}
+
+func TestMatcherPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		lists     []testList
+		query     string
+		wantMatch bool
+		wantList  string
+		wantRule  string
+		wantAllow bool
+	}{
+		{
+			name: "allow in later list beats block",
+			lists: []testList{
+				{List{Name: "ads"}, []string{"0.0.0.0 www.ads.example", "ads.example"}},
+				{List{Name: "fixes"}, []string{"@@||www.ads.example^"}},
+			},
+			query: "www.ads.example.", wantMatch: true, wantList: "fixes", wantRule: "@@||www.ads.example^", wantAllow: true,
+		},
+		{
+			name: "allowlist beats exact block",
+			lists: []testList{
+				{List{Name: "ads"}, []string{"0.0.0.0 cdn.example.com"}},
+				{List{Name: "allow", Allow: true}, []string{"example.com"}},
+			},
+			query: "cdn.example.com.", wantMatch: true, wantList: "allow", wantRule: "example.com", wantAllow: true,
+		},
+		{
+			name: "exact before suffix",
+			lists: []testList{
+				{List{Name: "first"}, []string{"||example.com^"}},
+				{List{Name: "second"}, []string{"0.0.0.0 ads.example.com"}},
+			},
+			query: "ads.example.com.", wantMatch: true, wantList: "second", wantRule: "0.0.0.0 ads.example.com",
+		},
+		{
+			name: "most specific suffix first",
+			lists: []testList{
+				{List{Name: "first"}, []string{"example.com"}},
+				{List{Name: "second"}, []string{"ads.example.com"}},
+			},
+			query: "x.ads.example.com.", wantMatch: true, wantList: "second", wantRule: "ads.example.com",
+		},
+		{
+			name: "suffix before glob",
+			lists: []testList{
+				{List{Name: "first"}, []string{"*.example.com"}},
+				{List{Name: "second"}, []string{"example.com"}},
+			},
+			query: "a.example.com.", wantMatch: true, wantList: "second", wantRule: "example.com",
+		},
+		{
+			name: "glob before regex",
+			lists: []testList{
+				{List{Name: "first"}, []string{`/^ads[0-9]+\./`}},
+				{List{Name: "second"}, []string{"ads*.example"}},
+			},
+			query: "ads1.example.", wantMatch: true, wantList: "second", wantRule: "ads*.example",
+		},
+		{
+			name: "first list wins for same kind",
+			lists: []testList{
+				{List{Name: "first"}, []string{"||ads.example^"}},
+				{List{Name: "second"}, []string{"ads.example"}},
+			},
+			query: "ads.example.", wantMatch: true, wantList: "first", wantRule: "||ads.example^",
+		},
+		{
+			name:  "hosts line blocks exact name",
+			lists: []testList{{List{Name: "hosts"}, []string{"0.0.0.0 ads.example"}}},
+			query: "ADS.Example.", wantMatch: true, wantList: "hosts", wantRule: "0.0.0.0 ads.example",
+		},
+		{
+			name:  "hosts line does not block subdomains",
+			lists: []testList{{List{Name: "hosts"}, []string{"0.0.0.0 ads.example"}}},
+			query: "sub.ads.example.",
+		},
+		{
+			name:  "plain domain blocks subdomains",
+			lists: []testList{{List{Name: "domains"}, []string{"ads.example"}}},
+			query: "sub.ads.example.", wantMatch: true, wantList: "domains", wantRule: "ads.example",
+		},
+		{
+			name:  "adblock rule blocks subdomains",
+			lists: []testList{{List{Name: "adblock"}, []string{"||ads.example^"}}},
+			query: "sub.ads.example.", wantMatch: true, wantList: "adblock", wantRule: "||ads.example^",
+		},
+		{
+			name:  "allow glob",
+			lists: []testList{{List{Name: "mixed"}, []string{"||example.com^", "@@*.cdn.example.com"}}},
+			query: "img.cdn.example.com.", wantMatch: true, wantList: "mixed", wantRule: "@@*.cdn.example.com", wantAllow: true,
+		},
+		{
+			name:  "allow glob does not cover other names",
+			lists: []testList{{List{Name: "mixed"}, []string{"||example.com^", "@@*.cdn.example.com"}}},
+			query: "img.example.com.", wantMatch: true, wantList: "mixed", wantRule: "||example.com^",
+		},
+		{
+			name:  "allow regex",
+			lists: []testList{{List{Name: "mixed"}, []string{"||example.com^", `@@/^api[0-9]\.example\.com$/`}}},
+			query: "api1.example.com.", wantMatch: true, wantList: "mixed", wantRule: `@@/^api[0-9]\.example\.com$/`, wantAllow: true,
+		},
+		{
+			name:  "no match",
+			lists: []testList{{List{Name: "ads"}, []string{"ads.example"}}},
+			query: "example.org.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := compileTest(t, tt.lists).match(tt.query)
+			if ok != tt.wantMatch {
+				t.Fatalf("match(%s) ok = %v, want %v (%+v)", tt.query, ok, tt.wantMatch, match)
+			}
+			if match.List != tt.wantList || match.Rule != tt.wantRule || match.Allow != tt.wantAllow {
+				t.Errorf("match(%s) = %+v, want list %q rule %q allow %v", tt.query, match, tt.wantList, tt.wantRule, tt.wantAllow)
+			}
+		})
+	}
+}
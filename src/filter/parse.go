@@ -0,0 +1,199 @@
+package filter
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ruleKind menentukan cara rule dicocokkan dengan nama yang ditanyakan
+type ruleKind int
+
+const (
+	kindExact  ruleKind = iota // hanya nama itu sendiri
+	kindSuffix                 // nama itu dan semua subdomain-nya
+	kindGlob                   // pola dengan * dan ?, dicocokkan dengan nama lengkap
+	kindRegex                  // regular expression (RE2)
+)
+
+// rule adalah satu baris list yang sudah di-parse
+type rule struct {
+	kind    ruleKind
+	allow   bool           // exception (@@), selalu menang atas rule block
+	domain  string         // exact dan suffix: lowercase tanpa titik di akhir
+	pattern *regexp.Regexp // glob dan regex
+	text    string         // baris asli, untuk log
+}
+
+// hostsIgnored adalah nama bawaan file hosts yang bukan rule blokir
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// parseLine mengubah satu baris list menjadi rule. Format dikenali per baris:
+//
+//	0.0.0.0 ads.example      file hosts, exact
+//	ads.example              daftar domain, termasuk subdomain
+//	||ads.example^           Adblock, termasuk subdomain
+//	*.tracking.*             glob, * dan ? dicocokkan dengan nama lengkap
+//	/^ad[0-9]+\./            regex, dicocokkan dengan nama tanpa titik di akhir
+//
+// Awalan @@ menjadikan rule apa pun (kecuali baris hosts) sebagai rule allow.
+// Komentar (#, !), baris kosong dan rule Adblock yang tidak didukung
+// (kosmetik, modifier $) menghasilkan ok = false
+func parseLine(line string) (rules []rule, ok bool) {
+	text := strings.TrimSpace(line)
+	line = text
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return nil, false
+	}
+
+	allow := strings.HasPrefix(line, "@@")
+	if allow {
+		line = line[2:]
+	}
+
+	var r rule
+	switch {
+	case strings.HasPrefix(line, "||"):
+		r, ok = parseAdblock(line)
+	case len(line) > 2 && line[0] == '/' && line[len(line)-1] == '/':
+		r, ok = parseRegex(line[1 : len(line)-1])
+	case strings.ContainsAny(line, "*?"):
+		r, ok = parseGlob(line)
+	default:
+		if allow {
+			r, ok = parseDomain(line)
+			break
+		}
+		return parseHosts(line)
+	}
+	if !ok {
+		return nil, false
+	}
+	r.allow = allow
+	r.text = text
+	return []rule{r}, true
+}
+
+// parseHosts mem-parse baris file hosts atau daftar domain
+func parseHosts(line string) (rules []rule, ok bool) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	// file hosts: IP diikuti satu atau lebih nama
+	if net.ParseIP(fields[0]) != nil {
+		for _, name := range fields[1:] {
+			domain, ok := normalize(name)
+			if !ok || hostsIgnored[domain] {
+				continue
+			}
+			rules = append(rules, rule{kind: kindExact, domain: domain, text: line})
+		}
+		return rules, len(rules) > 0
+	}
+
+	if len(fields) != 1 {
+		return nil, false
+	}
+	r, ok := parseDomain(fields[0])
+	if !ok {
+		return nil, false
+	}
+	r.text = line
+	return []rule{r}, true
+}
+
+// parseDomain mem-parse satu nama domain sebagai rule suffix
+func parseDomain(name string) (rule, bool) {
+	domain, ok := normalize(name)
+	if !ok {
+		return rule{}, false
+	}
+	return rule{kind: kindSuffix, domain: domain}, true
+}
+
+// parseRegex mengompilasi regex; sintaks di luar RE2 (misal lookahead) dilewati
+func parseRegex(expr string) (rule, bool) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return rule{}, false
+	}
+	return rule{kind: kindRegex, pattern: pattern}, true
+}
+
+// parseGlob mengubah glob menjadi regex yang dicocokkan dengan nama lengkap:
+// * untuk nol atau lebih karakter (termasuk titik), ? untuk satu karakter
+func parseGlob(glob string) (rule, bool) {
+	glob = strings.ToLower(strings.TrimSuffix(glob, "."))
+	if strings.ContainsAny(glob, " \t/^|$@") {
+		return rule{}, false
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	r, ok := parseRegex(expr.String())
+	r.kind = kindGlob
+	return r, ok
+}
+
+// parseAdblock mem-parse rule ||domain^ (awalan @@ sudah dibuang)
+func parseAdblock(line string) (rule, bool) {
+	body := line[2:]
+
+	// modifier seperti $client atau $dnstype tidak didukung; rule dilewati
+	// daripada diterapkan lebih luas dari maksudnya
+	if i := strings.IndexByte(body, '$'); i >= 0 {
+		if body[i+1:] != "important" {
+			return rule{}, false
+		}
+		body = body[:i]
+	}
+	body = strings.TrimSuffix(body, "^")
+	body = strings.TrimSuffix(body, "|")
+
+	return parseDomain(body)
+}
+
+// normalize mengubah nama menjadi lowercase tanpa titik di akhir, dan
+// menolak nama yang bukan domain (berisi wildcard, path, dan sebagainya)
+func normalize(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || strings.ContainsAny(name, "*/^|$@") {
+		return "", false
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,192 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ListStats berisi jumlah rule dan status update satu list
+type ListStats struct {
+	Name        string     `json:"name"`
+	Source      string     `json:"source"`
+	Rules       int        `json:"rules"`
+	Skipped     int        `json:"skipped"`                // baris yang tidak didukung
+	LastUpdated *time.Time `json:"last_updated,omitempty"` // terakhir isi list berubah
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// source adalah satu list beserta rule hasil parse terakhir dan validator
+// HTTP (ETag, Last-Modified) atau waktu modifikasi file untuk refresh
+type source struct {
+	list List
+
+	mu           sync.Mutex
+	rules        []rule
+	skipped      int
+	loaded       bool
+	etag         string
+	lastModified string
+	modTime      time.Time
+	lastUpdated  time.Time
+	lastChecked  time.Time
+	lastError    string
+}
+
+func (s *source) location() string {
+	if s.list.URL != "" {
+		return s.list.URL
+	}
+	return s.list.Path
+}
+
+// update membaca ulang list jika isinya berubah. changed bernilai false jika
+// server menjawab 304 Not Modified atau file tidak berubah. Jika gagal, rule
+// lama tetap dipakai
+func (s *source) update(client *http.Client) (changed bool, err error) {
+	// validator hanya diganti jika isi baru berhasil di-parse, agar list yang
+	// gagal di-parse diunduh ulang pada refresh berikutnya
+	etag, lastModified, modTime := s.etag, s.lastModified, s.modTime
+
+	var body io.ReadCloser
+	if s.list.URL != "" {
+		body, err = s.fetch(client)
+	} else {
+		body, err = s.open()
+	}
+
+	if err == nil && body != nil {
+		defer body.Close()
+		var rules []rule
+		var skipped int
+		rules, skipped, err = parse(body)
+		if err == nil {
+			s.mu.Lock()
+			s.rules = rules
+			s.skipped = skipped
+			s.loaded = true
+			s.lastUpdated = time.Now()
+			s.mu.Unlock()
+			changed = true
+		}
+	}
+	if err != nil {
+		s.etag, s.lastModified, s.modTime = etag, lastModified, modTime
+	}
+
+	s.mu.Lock()
+	s.lastChecked = time.Now()
+	s.lastError = ""
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	s.mu.Unlock()
+	return changed, err
+}
+
+// fetch mengunduh list dengan conditional GET; body nil berarti tidak berubah
+func (s *source) fetch(client *http.Client) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.list.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.loaded {
+		if s.etag != "" {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if s.lastModified != "" {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		s.etag = resp.Header.Get("ETag")
+		s.lastModified = resp.Header.Get("Last-Modified")
+		return resp.Body, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// open membuka file list; body nil berarti waktu modifikasinya tidak berubah
+func (s *source) open() (io.ReadCloser, error) {
+	info, err := os.Stat(s.list.Path)
+	if err != nil {
+		return nil, err
+	}
+	if s.loaded && info.ModTime().Equal(s.modTime) {
+		return nil, nil
+	}
+	file, err := os.Open(s.list.Path)
+	if err != nil {
+		return nil, err
+	}
+	s.modTime = info.ModTime()
+	return file, nil
+}
+
+func (s *source) logLoaded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log.Printf("[INFO] Loaded %d rules from list %s (%d unsupported lines skipped)", len(s.rules), s.list.Name, s.skipped)
+}
+
+func (s *source) stats() ListStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := ListStats{
+		Name:      s.list.Name,
+		Source:    s.location(),
+		Rules:     len(s.rules),
+		Skipped:   s.skipped,
+		LastError: s.lastError,
+	}
+	if !s.lastUpdated.IsZero() {
+		lastUpdated := s.lastUpdated
+		stats.LastUpdated = &lastUpdated
+	}
+	if !s.lastChecked.IsZero() {
+		lastChecked := s.lastChecked
+		stats.LastChecked = &lastChecked
+	}
+	return stats
+}
+
+// parse membaca semua baris list. skipped adalah jumlah baris yang bukan
+// komentar tetapi tidak didukung
+func parse(r io.Reader) (rules []rule, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parsed, ok := parseLine(scanner.Text())
+		if !ok {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !isComment(line) {
+				skipped++
+			}
+			continue
+		}
+		rules = append(rules, parsed...)
+	}
+	return rules, skipped, scanner.Err()
+}
+
+func isComment(line string) bool {
+	return line[0] == '#' || line[0] == '!' || line[0] == '['
+}
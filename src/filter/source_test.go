@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+func TestSourceConditionalRefresh(t *testing.T) {
+	var requests atomic.Int32
+	var ifNoneMatch, ifModifiedSince string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		ifModifiedSince = r.Header.Get("If-Modified-Since")
+		if ifNoneMatch == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprintln(w, "ads.example")
+		fmt.Fprintln(w, "0.0.0.0 tracker.example")
+	}))
+	defer srv.Close()
+
+	s := &source{list: List{Name: "test", URL: srv.URL}}
+	changed, err := s.update(srv.Client())
+	if err != nil || !changed {
+		t.Fatalf("first update() = %v, %v, want true, nil", changed, err)
+	}
+	if ifNoneMatch != "" || ifModifiedSince != "" {
+		t.Errorf("first request sent validators %q, %q", ifNoneMatch, ifModifiedSince)
+	}
+	if len(s.rules) != 2 {
+		t.Fatalf("rules after 200 = %d, want 2", len(s.rules))
+	}
+
+	changed, err = s.update(srv.Client())
+	if err != nil || changed {
+		t.Fatalf("second update() = %v, %v, want false, nil", changed, err)
+	}
+	if ifNoneMatch != `"v1"` || ifModifiedSince != lastModified {
+		t.Errorf("second request validators = %q, %q, want %q, %q", ifNoneMatch, ifModifiedSince, `"v1"`, lastModified)
+	}
+	if len(s.rules) != 2 {
+		t.Errorf("rules after 304 = %d, want 2", len(s.rules))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("requests = %d, want 2", n)
+	}
+}
+
+func TestRefreshKeepsRulesOnFailure(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintln(w, "ads.example")
+	}))
+	defer srv.Close()
+
+	f, err := New([]List{{Name: "test", URL: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Match("ads.example."); !ok {
+		t.Fatal("Match() = no match after initial load")
+	}
+
+	failing.Store(true)
+	f.Refresh()
+
+	if match, ok := f.Match("www.ads.example."); !ok || match.List != "test" {
+		t.Errorf("Match() after failed refresh = %+v, %v, want old rule", match, ok)
+	}
+	stats := f.Stats()[0]
+	if stats.Rules != 1 || stats.LastError == "" {
+		t.Errorf("Stats() = %+v, want 1 rule and last_error set", stats)
+	}
+
+	// validator lama tetap dipakai setelah refresh gagal
+	s := f.sources[0]
+	if s.etag != `"v1"` {
+		t.Errorf("etag after failed refresh = %q, want %q", s.etag, `"v1"`)
+	}
+}
+
+func TestMatchDuringRefresh(t *testing.T) {
+	// setiap unduhan berganti isi: "always.example" selalu ada, "v<n>.example"
+	// berubah, sehingga setiap refresh mengganti matcher
+	var version atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := version.Add(1)
+		fmt.Fprintln(w, "||always.example^")
+		fmt.Fprintf(w, "v%d.example\n", n)
+	}))
+	defer srv.Close()
+
+	f, err := New([]List{{Name: "test", URL: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				match, ok := f.Match("ads.always.example.")
+				if !ok || match.Rule != "||always.example^" {
+					failures.Add(1)
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		f.Refresh()
+	}
+	close(stop)
+	wg.Wait()
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("Match() missed a rule present in every version %d times", n)
+	}
+	latest := fmt.Sprintf("v%d.example", version.Load())
+	if _, ok := f.Match(latest); !ok {
+		t.Errorf("Match(%s) = no match after last refresh", latest)
+	}
+	if _, ok := f.Match("v1.example"); ok {
+		t.Error("Match(v1.example) still matches a rule from the first version")
+	}
+}
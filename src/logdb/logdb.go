@@ -11,20 +11,29 @@ import (
 
 // Struktur log DNS
 type DNSLog struct {
-	Timestamp   int64  `json:"timestamp"` // Unix timestamp nanodetik
-	ClientIP    string `json:"client_ip"`
-	Query       string `json:"query"`
-	QueryType   int    `json:"query_type"`
-	Resolver    string `json:"resolver"`
-	ResolverURL string `json:"resolver_url"`
-	Response    []struct {
-		Name string `json:"name"`
-		Type int    `json:"type"`
-		Class int	`json:"class"`
-		TTL  int    `json:"TTL"`
-		Data string `json:"data"`
-	} `json:"response"`
-	Comment []string `json:"comment"`
+	Timestamp   int64       `json:"timestamp"` // Unix timestamp nanodetik
+	ClientIP    string      `json:"client_ip"`
+	Query       string      `json:"query"`
+	QueryType   int         `json:"query_type"`
+	Resolver    string      `json:"resolver"`
+	ResolverURL string      `json:"resolver_url"`
+	Transport   string      `json:"transport"`              // udp, tcp, dot, doh
+	ForwardRule string      `json:"forward_rule,omitempty"` // rule conditional forwarding yang cocok
+	Blocked     bool        `json:"blocked,omitempty"`      // diblokir filter domain
+	FilterList  string      `json:"filter_list,omitempty"`  // list yang memblokir, atau yang mengizinkan jika Blocked false
+	FilterRule  string      `json:"filter_rule,omitempty"`  // rule yang cocok, untuk melacak false positive
+	FilterHop   string      `json:"filter_hop,omitempty"`   // nama CNAME yang diblokir (CNAME cloaking)
+	Response    []DNSRecord `json:"response"`
+	Comment     []string    `json:"comment"`
+}
+
+// DNSRecord adalah satu record pada response yang dicatat di log
+type DNSRecord struct {
+	Name  string `json:"name"`
+	Type  int    `json:"type"`
+	Class int    `json:"class"`
+	TTL   int    `json:"TTL"`
+	Data  string `json:"data"`
 }
 
 // LogManager untuk mengelola penyimpanan log
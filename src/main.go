@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go.blok.doh/cache"
 	"go.blok.doh/doh"
+	"go.blok.doh/filter"
+	"go.blok.doh/logdb"
 	"go.blok.doh/server"
 
 	"github.com/spf13/viper"
@@ -26,6 +32,68 @@ type ServerConfig struct {
 	UDPPort        int  `mapstructure:"udp_port"`
 	BufferSize     int  `mapstructure:"buffer_size"`
 	EnableRecusion bool `mapstructure:"enable_recursion"`
+	EnableTCP      bool `mapstructure:"enable_tcp"`
+	TCPIdleTimeout int  `mapstructure:"tcp_idle_timeout"`
+	QueryTimeout   int  `mapstructure:"query_timeout"`
+}
+
+type HTTPSServerConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Port     int    `mapstructure:"port"`
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+type TLSServerConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	Port        int    `mapstructure:"port"`
+	CertFile    string `mapstructure:"cert_file"`
+	KeyFile     string `mapstructure:"key_file"`
+	IdleTimeout int    `mapstructure:"idle_timeout"`
+}
+
+type CacheConfig struct {
+	MaxEntries     int   `mapstructure:"max_entries"`
+	MaxBytes       int64 `mapstructure:"max_bytes"`
+	Shards         int   `mapstructure:"shards"`
+	NegativeMaxTTL int   `mapstructure:"negative_max_ttl"`
+	ServFailTTL    int   `mapstructure:"servfail_ttl"`
+
+	StaleWindow        int `mapstructure:"stale_window"`
+	StaleTTL           int `mapstructure:"stale_ttl"`
+	StaleAnswerTimeout int `mapstructure:"stale_answer_timeout"`
+
+	PrefetchMinHits     int `mapstructure:"prefetch_min_hits"`
+	PrefetchConcurrency int `mapstructure:"prefetch_concurrency"`
+
+	SnapshotPath     string `mapstructure:"snapshot_path"`
+	SnapshotInterval int    `mapstructure:"snapshot_interval"`
+}
+
+type HealthCheckConfig struct {
+	FailureThreshold int    `mapstructure:"failure_threshold"`
+	BaseBackoff      int    `mapstructure:"base_backoff"`
+	MaxBackoff       int    `mapstructure:"max_backoff"`
+	ProbeInterval    int    `mapstructure:"probe_interval"`
+	ProbeName        string `mapstructure:"probe_name"`
+}
+
+type ResolverGroupConfig struct {
+	Strategy  string         `mapstructure:"strategy"`
+	Resolvers []doh.Resolver `mapstructure:"resolvers"`
+}
+
+type StatusServerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Port    int  `mapstructure:"port"`
+}
+
+type FilterConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Mode            string        `mapstructure:"mode"`
+	BlockTTL        int           `mapstructure:"block_ttl"`
+	RefreshInterval int           `mapstructure:"refresh_interval"`
+	Lists           []filter.List `mapstructure:"lists"`
 }
 
 type RateLimitCfg struct {
@@ -35,10 +103,19 @@ type RateLimitCfg struct {
 
 type Config struct {
 	DOH struct {
-		Resolvers []doh.Resolver `mapstructure:"resolvers"`
+		Resolvers   []doh.Resolver                 `mapstructure:"resolvers"`
+		Strategy    string                         `mapstructure:"strategy"`
+		HealthCheck HealthCheckConfig              `mapstructure:"health_check"`
+		Groups      map[string]ResolverGroupConfig `mapstructure:"groups"`
+		Forwarding  []doh.ForwardRule              `mapstructure:"forwarding"`
 	} `mapstructure:"doh"`
-	Server    ServerConfig `mapstructure:"server"`
-	RateLimit RateLimitCfg `mapstructure:"rate_limit"`
+	Server       ServerConfig       `mapstructure:"server"`
+	DOHServer    HTTPSServerConfig  `mapstructure:"doh_server"`
+	DOTServer    TLSServerConfig    `mapstructure:"dot_server"`
+	Cache        CacheConfig        `mapstructure:"cache"`
+	RateLimit    RateLimitCfg       `mapstructure:"rate_limit"`
+	StatusServer StatusServerConfig `mapstructure:"status_server"`
+	Filter       FilterConfig       `mapstructure:"filter"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -76,17 +153,189 @@ func main() {
 		udpPort = *udpPortArg
 	}
 
-	log.Println("[INFO] Initializing DOH client...")
-	dohClient := doh.NewDOHClient(cfg.DOH.Resolvers)
-	log.Println("[INFO] DOH client initialized.")
+	log.Println("[INFO] Initializing upstream pool...")
+	healthCfg := cfg.DOH.HealthCheck
+	healthOpts := doh.HealthOptions{
+		FailureThreshold: healthCfg.FailureThreshold,
+		BaseBackoff:      time.Duration(healthCfg.BaseBackoff) * time.Second,
+		MaxBackoff:       time.Duration(healthCfg.MaxBackoff) * time.Second,
+		ProbeName:        healthCfg.ProbeName,
+	}
+	if healthCfg.ProbeInterval <= 0 {
+		healthCfg.ProbeInterval = 10
+	}
+	probeInterval := time.Duration(healthCfg.ProbeInterval) * time.Second
+
+	upstreams := doh.NewPoolWithOptions(cfg.DOH.Resolvers, doh.Options{
+		Strategy: cfg.DOH.Strategy,
+		Health:   healthOpts,
+	})
+	upstreams.StartHealthCheck(probeInterval)
+
+	// Grup resolver untuk conditional forwarding, misal DNS LAN untuk zona internal
+	groups := make(map[string]*doh.Pool, len(cfg.DOH.Groups))
+	for name, group := range cfg.DOH.Groups {
+		groups[name] = doh.NewPoolWithOptions(group.Resolvers, doh.Options{
+			Strategy: group.Strategy,
+			Health:   healthOpts,
+		})
+		groups[name].StartHealthCheck(probeInterval)
+	}
+	var forwarder *doh.Forwarder
+	if len(cfg.DOH.Forwarding) > 0 {
+		forwarder, err = doh.NewForwarder(cfg.DOH.Forwarding, groups)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid forwarding config: %v", err)
+		}
+		log.Printf("[INFO] Loaded %d forwarding rules.", len(cfg.DOH.Forwarding))
+	}
+	log.Println("[INFO] Upstream pool initialized.")
+
+	logManager, err := logdb.NewLogManager("./dns_logs")
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to open log database: %v", err)
+	}
+	defer logManager.Close()
+
+	dnsCache := cache.NewDNSTTLCacheWithOptions(cache.Options{
+		MaxEntries: cfg.Cache.MaxEntries,
+		MaxBytes:   cfg.Cache.MaxBytes,
+		Shards:     cfg.Cache.Shards,
+
+		StaleWindow: time.Duration(cfg.Cache.StaleWindow) * time.Second,
+	})
+	dnsCache.StartCleanupLoop(30 * time.Second)
+
+	if cfg.Cache.SnapshotPath != "" {
+		loaded, err := dnsCache.LoadSnapshot(cfg.Cache.SnapshotPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] Failed to load cache snapshot: %v", err)
+		} else {
+			log.Printf("[INFO] Loaded %d cache entries from snapshot.", loaded)
+		}
+		if cfg.Cache.SnapshotInterval > 0 {
+			dnsCache.StartSnapshotLoop(cfg.Cache.SnapshotPath, time.Duration(cfg.Cache.SnapshotInterval)*time.Second)
+		}
+	}
+
+	// Graceful shutdown: simpan snapshot cache dan tutup database log
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+
+		log.Println("[INFO] Shutting down go.blok.doh...")
+		if cfg.Cache.SnapshotPath != "" {
+			if err := dnsCache.SaveSnapshot(cfg.Cache.SnapshotPath); err != nil {
+				log.Printf("[ERROR] Failed to save cache snapshot: %v", err)
+			} else {
+				log.Println("[INFO] Cache snapshot saved.")
+			}
+		}
+		logManager.Close()
+		os.Exit(0)
+	}()
+
+	if cfg.Cache.StaleTTL <= 0 {
+		cfg.Cache.StaleTTL = 30
+	}
+
+	handler := &server.Handler{
+		Upstreams:   upstreams,
+		Forwarder:   forwarder,
+		Cache:       dnsCache,
+		RateLimiter: server.NewRateLimiterMap(rate.Limit(cfg.RateLimit.MaxRequests), cfg.RateLimit.MaxRequests),
+		LogManager:  logManager,
+		TTLPolicy: cache.TTLPolicy{
+			NegativeMaxTTL: uint32(cfg.Cache.NegativeMaxTTL),
+			ServFailTTL:    uint32(cfg.Cache.ServFailTTL),
+		},
+		StaleTTL:           uint32(cfg.Cache.StaleTTL),
+		StaleAnswerTimeout: time.Duration(cfg.Cache.StaleAnswerTimeout) * time.Millisecond,
+		QueryTimeout:       time.Duration(cfg.Server.QueryTimeout) * time.Millisecond,
+	}
+	if cfg.Filter.Enabled {
+		if cfg.Filter.Mode == "" {
+			cfg.Filter.Mode = filter.ModeNXDomain
+		}
+		if !filter.ValidMode(cfg.Filter.Mode) {
+			log.Fatalf("[ERROR] Invalid filter mode: %s", cfg.Filter.Mode)
+		}
+		if cfg.Filter.BlockTTL <= 0 {
+			cfg.Filter.BlockTTL = 10
+		}
+		handler.BlockMode = cfg.Filter.Mode
+		handler.BlockTTL = uint32(cfg.Filter.BlockTTL)
+
+		log.Println("[INFO] Loading blocklists...")
+		handler.Filter, err = filter.New(cfg.Filter.Lists)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to load blocklists: %v", err)
+		}
+		if cfg.Filter.RefreshInterval > 0 {
+			handler.Filter.StartRefreshLoop(time.Duration(cfg.Filter.RefreshInterval) * time.Second)
+		}
+	}
+	if cfg.Cache.PrefetchMinHits > 0 {
+		handler.Prefetcher = server.NewPrefetcher(uint32(cfg.Cache.PrefetchMinHits), cfg.Cache.PrefetchConcurrency)
+	}
+
+	if cfg.Server.EnableTCP {
+		if cfg.Server.TCPIdleTimeout <= 0 {
+			cfg.Server.TCPIdleTimeout = 10
+		}
+		log.Printf("[INFO] Starting TCP server on port %d...\n", udpPort)
+		tcpServer := &server.TCPServer{
+			Port:        udpPort,
+			IdleTimeout: time.Duration(cfg.Server.TCPIdleTimeout) * time.Second,
+			Handler:     handler,
+		}
+		go tcpServer.Start()
+	}
+
+	if cfg.DOHServer.Enabled {
+		log.Printf("[INFO] Starting HTTPS (DoH) server on port %d...\n", cfg.DOHServer.Port)
+		httpsServer := &server.HTTPSServer{
+			Port:     cfg.DOHServer.Port,
+			CertFile: cfg.DOHServer.CertFile,
+			KeyFile:  cfg.DOHServer.KeyFile,
+			Handler:  handler,
+		}
+		go httpsServer.Start()
+	}
+
+	if cfg.DOTServer.Enabled {
+		if cfg.DOTServer.IdleTimeout <= 0 {
+			cfg.DOTServer.IdleTimeout = 30
+		}
+		log.Printf("[INFO] Starting TLS (DoT) server on port %d...\n", cfg.DOTServer.Port)
+		tlsServer := &server.TLSServer{
+			Port:        cfg.DOTServer.Port,
+			CertFile:    cfg.DOTServer.CertFile,
+			KeyFile:     cfg.DOTServer.KeyFile,
+			IdleTimeout: time.Duration(cfg.DOTServer.IdleTimeout) * time.Second,
+			Handler:     handler,
+		}
+		go tlsServer.Start()
+	}
+
+	if cfg.StatusServer.Enabled {
+		log.Printf("[INFO] Starting status server on port %d...\n", cfg.StatusServer.Port)
+		statusServer := &server.StatusServer{
+			Port:      cfg.StatusServer.Port,
+			Upstreams: upstreams,
+			Groups:    groups,
+			Cache:     dnsCache,
+			Filter:    handler.Filter,
+		}
+		go statusServer.Start()
+	}
 
 	log.Printf("[INFO] Starting UDP server on port %d...\n", udpPort)
 	udpServer := &server.UDPServer{
-		Port:        udpPort,
-		BufferSize:  cfg.Server.BufferSize,
-		DOHClient:   dohClient,
-		Cache:       cache.NewDNSTTLCache(),
-		RateLimiter: server.NewRateLimiterMap(rate.Limit(cfg.RateLimit.MaxRequests), cfg.RateLimit.MaxRequests),
+		Port:       udpPort,
+		BufferSize: cfg.Server.BufferSize,
+		Handler:    handler,
 	}
 
 	udpServer.Start()
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/doh"
+	"go.blok.doh/filter"
+)
+
+// serveBlocked menjawab query yang cocok dengan blocklist. Jawaban dibuat
+// sebagai DOHResponse sintetis dan dilewatkan ke BuildResp agar format
+// response dan log sama dengan jawaban upstream. hop adalah nama CNAME yang
+// diblokir, kosong jika yang diblokir nama yang ditanyakan
+func (h *Handler) serveBlocked(msg *dns.Msg, response *dns.Msg, match filter.Match, hop string, clientIP string, transport string) *dns.Msg {
+	mode := match.Mode
+	if mode == "" {
+		mode = h.BlockMode
+	}
+	if hop != "" {
+		log.Printf("[INFO] Blocked %s via CNAME %s by list %s rule %q (%s)", msg.Question[0].Name, hop, match.List, match.Rule, mode)
+	} else {
+		log.Printf("[INFO] Blocked %s by list %s rule %q (%s)", msg.Question[0].Name, match.List, match.Rule, mode)
+	}
+
+	responseData := blockedResponse(msg.Question[0], mode, h.BlockTTL)
+	response, logEntry := BuildResp(msg.Question[0].Name, response, responseData, clientIP)
+	if response == nil {
+		return nil
+	}
+	fixEdns(msg, response)
+
+	// Extended DNS Error (RFC 8914): Blocked untuk kebijakan operator,
+	// Filtered untuk list yang diminta client
+	if opt := response.IsEdns0(); opt != nil {
+		code := dns.ExtendedErrorCodeBlocked
+		if match.Filtered {
+			code = dns.ExtendedErrorCodeFiltered
+		}
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: "blocked by " + match.List})
+	}
+
+	logEntry.Resolver = "Filter"
+	logEntry.ResolverURL = "filter://" + match.List
+	logEntry.Transport = transport
+	logEntry.Blocked = true
+	logEntry.FilterList = match.List
+	logEntry.FilterRule = match.Rule
+	logEntry.FilterHop = hop
+	h.LogManager.SaveLog(logEntry)
+	return response
+}
+
+// blockedCNAME memeriksa owner dan target tiap CNAME di jawaban upstream
+// (CNAME cloaking: tracker bersembunyi di balik CNAME first-party, misal
+// metrics.shop.com CNAME shop.tracker.net). Jika nama yang ditanyakan cocok
+// dengan rule allow, seluruh rantai CNAME ikut diizinkan
+func (h *Handler) blockedCNAME(domain string, responseData *doh.DOHResponse) (filter.Match, string, bool) {
+	if h.Filter == nil {
+		return filter.Match{}, "", false
+	}
+	if match, ok := h.Filter.Match(domain); ok && match.Allow {
+		return filter.Match{}, "", false
+	}
+	for _, hop := range cnameHops(responseData) {
+		if match, ok := h.Filter.Match(hop); ok && !match.Allow {
+			return match, hop, true
+		}
+	}
+	return filter.Match{}, "", false
+}
+
+// cnameHops mengembalikan owner dan target semua CNAME di answer section,
+// dari jawaban JSON maupun wire format
+func cnameHops(responseData *doh.DOHResponse) []string {
+	var hops []string
+	if len(responseData.Wire) > 0 {
+		upstream := new(dns.Msg)
+		if err := upstream.Unpack(responseData.Wire); err != nil {
+			return nil
+		}
+		for _, rr := range upstream.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok {
+				hops = append(hops, cname.Hdr.Name, cname.Target)
+			}
+		}
+		return hops
+	}
+
+	for _, answer := range responseData.Answer {
+		if uint16(answer.Type) == dns.TypeCNAME {
+			hops = append(hops, answer.Name, answer.Data)
+		}
+	}
+	return hops
+}
+
+// blockedResponse membuat jawaban untuk query yang diblokir sesuai mode.
+// Jawaban negatif membawa SOA dengan TTL yang sama agar client hanya
+// meng-cache-nya sebentar (RFC 2308)
+func blockedResponse(question dns.Question, mode string, ttl uint32) *doh.DOHResponse {
+	responseData := &doh.DOHResponse{Status: dns.RcodeSuccess, RA: true}
+
+	var ip net.IP
+	switch mode {
+	case filter.ModeRefused:
+		responseData.Status = dns.RcodeRefused
+		return responseData
+	case filter.ModeNXDomain:
+		responseData.Status = dns.RcodeNameError
+	case filter.ModeNull:
+		if question.Qtype == dns.TypeAAAA {
+			ip = net.IPv6zero
+		} else {
+			ip = net.IPv4zero
+		}
+	case filter.ModeNoData:
+	default:
+		ip = net.ParseIP(mode)
+	}
+
+	// IP hanya dijawab untuk tipe query yang sesuai; tipe lain mendapat NODATA
+	if ip != nil {
+		if ip4 := ip.To4(); ip4 != nil && question.Qtype == dns.TypeA {
+			responseData.Answer = append(responseData.Answer, doh.DOHRecord{Name: question.Name, Type: int(dns.TypeA), TTL: int(ttl), Data: ip4.String()})
+			return responseData
+		}
+		if ip.To4() == nil && question.Qtype == dns.TypeAAAA {
+			responseData.Answer = append(responseData.Answer, doh.DOHRecord{Name: question.Name, Type: int(dns.TypeAAAA), TTL: int(ttl), Data: ip.String()})
+			return responseData
+		}
+	}
+
+	responseData.Authority = append(responseData.Authority, doh.DOHRecord{
+		Name: question.Name,
+		Type: int(dns.TypeSOA),
+		TTL:  int(ttl),
+		Data: fmt.Sprintf("blocked.blok.doh. hostmaster.blok.doh. 1 %d %d %d %d", ttl, ttl, ttl, ttl),
+	})
+	return responseData
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/doh"
+)
+
+var update = flag.Bool("update", false, "tulis ulang file .golden di testdata")
+
+// TestBuildRespGolden membangun jawaban dari contoh JSON Cloudflare dan Google
+// di testdata lalu membandingkannya dengan file .golden. Jawaban di-pack dan
+// di-unpack dulu agar golden mencerminkan wire format. Kasus escape, ";" dan
+// string TXT di atas 255 byte ada di TestTxtStrings
+func TestBuildRespGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no testdata/*.json samples")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data doh.DOHResponse
+			if err := json.Unmarshal(raw, &data); err != nil {
+				t.Fatalf("unmarshal %s: %v", file, err)
+			}
+
+			question := data.Question[0]
+			req := new(dns.Msg)
+			req.SetQuestion(dns.Fqdn(question.Name), uint16(question.Type))
+			response := new(dns.Msg)
+			response.SetReply(req)
+
+			response, _ = BuildResp(question.Name, response, &data, "127.0.0.1")
+			if response == nil {
+				t.Fatal("BuildResp() returned nil")
+			}
+			wire, err := response.Pack()
+			if err != nil {
+				t.Fatalf("Pack() error = %v", err)
+			}
+			unpacked := new(dns.Msg)
+			if err := unpacked.Unpack(wire); err != nil {
+				t.Fatalf("Unpack() error = %v", err)
+			}
+
+			var got strings.Builder
+			got.WriteString(dns.RcodeToString[unpacked.Rcode] + "\n")
+			for _, rr := range append(unpacked.Answer, unpacked.Ns...) {
+				got.WriteString(rr.String() + "\n")
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("read golden (run with -update to create): %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("BuildResp() mismatch for %s\ngot:\n%s\nwant:\n%s", name, got.String(), want)
+			}
+		})
+	}
+}
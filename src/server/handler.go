@@ -0,0 +1,352 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/cache"
+	"go.blok.doh/doh"
+	"go.blok.doh/filter"
+	"go.blok.doh/logdb"
+	"golang.org/x/sync/singleflight"
+)
+
+// Handler menjalankan pipeline query DNS (rate limit, cache, DoH, log)
+// yang dipakai bersama oleh semua listener
+type Handler struct {
+	Upstreams   *doh.Pool
+	Forwarder   *doh.Forwarder // nil = semua query ke Upstreams
+	Cache       *cache.DNSTTLCache
+	RateLimiter *RateLimiterMap
+	LogManager  *logdb.LogManager
+	TTLPolicy   cache.TTLPolicy
+
+	StaleTTL           uint32        // TTL jawaban stale, RFC 8767 menyarankan 30 detik
+	StaleAnswerTimeout time.Duration // jawab dengan data stale jika upstream lebih lambat dari ini; 0 = tunggu upstream
+
+	Prefetcher *Prefetcher // nil = prefetch nonaktif
+
+	Filter    *filter.Filter // nil = tanpa blokir domain
+	BlockMode string         // jawaban untuk query yang diblokir, lihat filter.ModeNXDomain dan lainnya
+	BlockTTL  uint32         // TTL jawaban blokir
+
+	QueryTimeout time.Duration // batas waktu tunggu tiap client untuk jawaban upstream; 0 = tanpa batas
+
+	// inflight menggabungkan cache miss yang sama (cache key sama) agar
+	// hanya ada satu query upstream yang hasilnya dipakai bersama
+	inflight singleflight.Group
+}
+
+// Nama transport downstream yang dicatat di DNSLog
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+	TransportDoT = "dot"
+	TransportDoH = "doh"
+)
+
+// ErrRateLimited dikembalikan Serve jika query ditolak rate limiter
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// errNoResponse dikembalikan Serve jika query gagal diproses, misal data
+// cache rusak atau response upstream tidak bisa dibangun
+var errNoResponse = errors.New("failed to build response")
+
+// ServeDNS memproses satu query dan mengembalikan response-nya.
+// Nilai nil berarti query di-drop tanpa jawaban
+func (h *Handler) ServeDNS(msg *dns.Msg, clientIP string, transport string) *dns.Msg {
+	response, _ := h.Serve(msg, clientIP, transport)
+	return response
+}
+
+// Serve seperti ServeDNS, tetapi menjelaskan kenapa tidak ada response:
+// ErrRateLimited jika ditolak rate limiter, error lain jika query gagal diproses
+func (h *Handler) Serve(msg *dns.Msg, clientIP string, transport string) (*dns.Msg, error) {
+	limiter := h.RateLimiter.GetLimiter(clientIP)
+	if !limiter.Allow() {
+		log.Printf("[WARN] Rate limit exceeded for %s", clientIP)
+		return nil, ErrRateLimited
+	}
+
+	response := h.serve(msg, clientIP, transport)
+	if response == nil {
+		return nil, errNoResponse
+	}
+	return response, nil
+}
+
+// serve menjalankan pipeline query setelah lolos rate limit
+func (h *Handler) serve(msg *dns.Msg, clientIP string, transport string) *dns.Msg {
+	if len(msg.Question) == 0 {
+		log.Printf("[ERROR] No question found in DNS query")
+		return nil
+	}
+
+	domain := msg.Question[0].Name
+	qtype := msg.Question[0].Qtype
+	cacheKey := cacheKeyFor(msg)
+
+	log.Printf("[INFO] Received query for %s (type: %d) from %s over %s", domain, qtype, clientIP, transport)
+
+	_, forwardRule := h.upstreamFor(domain)
+
+	response := new(dns.Msg)
+	response.SetReply(msg)
+	response.Compress = true
+
+	// Blocklist dicek sebelum cache agar perubahan list langsung berlaku.
+	// Rule allow yang cocok dicatat di log agar false positive bisa dilacak
+	var allowed filter.Match
+	if h.Filter != nil {
+		if match, ok := h.Filter.Match(domain); ok {
+			if !match.Allow {
+				return h.serveBlocked(msg, response, match, "", clientIP, transport)
+			}
+			log.Printf("[DEBUG] %s allowed by list %s: %s", domain, match.List, match.Rule)
+			allowed = match
+		}
+	}
+
+	if cachedData, remaining, found := h.Cache.GetWithTTL(cacheKey); found {
+		log.Printf("[INFO] Found %t,  Cache hit for %s", found, cacheKey)
+		var responseData *doh.DOHResponse
+		if err := json.Unmarshal(cachedData.([]byte), &responseData); err != nil {
+			log.Printf("[ERROR] Failed to deserialize DOHResponse: %v", err)
+			return nil
+		}
+		if match, hop, blocked := h.blockedCNAME(domain, responseData); blocked {
+			return h.serveBlocked(msg, response, match, hop, clientIP, transport)
+		}
+		var logEntry logdb.DNSLog
+		response, logEntry = BuildResp(domain, response, responseData, clientIP)
+		if response != nil {
+			fixEdns(msg, response)
+			h.TTLPolicy.AgeTTL(response, remaining)
+			log.Print("[INFO] response from cache sent")
+			logEntry.Resolver = "Cache"
+			logEntry.ResolverURL = "cache://" + cacheKey
+			logEntry.Transport = transport
+			logEntry.ForwardRule = forwardRule
+			logEntry.FilterList, logEntry.FilterRule = allowed.List, allowed.Rule
+
+			h.LogManager.SaveLog(logEntry)
+
+			if h.Prefetcher != nil && h.Cache.ShouldPrefetch(cacheKey, h.Prefetcher.MinHits) {
+				h.prefetch(msg, clientIP, cacheKey, transport)
+			}
+		} else {
+			log.Print("[ERROR] response from cache error")
+		}
+		return response
+	}
+
+	// Data stale (RFC 8767) dipakai jika upstream gagal atau terlalu lambat
+	staleData, hasStale := h.Cache.GetStale(cacheKey)
+
+	// Query upstream tetap berjalan walau client ini sudah dijawab (timeout
+	// atau stale), sehingga cache tetap diperbarui untuk client berikutnya
+	resultCh := h.resolveShared(msg, clientIP, cacheKey, hasStale)
+
+	var staleTimeout, queryTimeout <-chan time.Time
+	if hasStale && h.StaleAnswerTimeout > 0 {
+		staleTimeout = time.After(h.StaleAnswerTimeout)
+	}
+	if h.QueryTimeout > 0 {
+		queryTimeout = time.After(h.QueryTimeout)
+	}
+
+	var result upstreamResult
+	select {
+	case shared := <-resultCh:
+		result = shared.Val.(upstreamResult)
+		if shared.Shared {
+			log.Printf("[DEBUG] Shared upstream answer for %s", cacheKey)
+		}
+	case <-staleTimeout:
+		log.Printf("[WARN] Upstream too slow for %s, serving stale answer", cacheKey)
+		return h.serveStale(msg, response, staleData, allowed, clientIP, cacheKey, transport)
+	case <-queryTimeout:
+		log.Printf("[ERROR] Timed out waiting for upstream answer for %s", cacheKey)
+		if hasStale {
+			return h.serveStale(msg, response, staleData, allowed, clientIP, cacheKey, transport)
+		}
+		response.Rcode = dns.RcodeServerFailure
+		return response
+	}
+
+	if hasStale && result.failed() {
+		log.Printf("[WARN] Upstream failed for %s, serving stale answer", cacheKey)
+		return h.serveStale(msg, response, staleData, allowed, clientIP, cacheKey, transport)
+	}
+
+	if result.err != nil {
+		log.Printf("[ERROR] Failed to resolve domain: %v", result.err)
+		response.Rcode = dns.RcodeServerFailure
+		return response
+	}
+
+	if match, hop, blocked := h.blockedCNAME(domain, result.responseData); blocked {
+		return h.serveBlocked(msg, response, match, hop, clientIP, transport)
+	}
+
+	var logEntry logdb.DNSLog
+	response, logEntry = BuildResp(domain, response, result.responseData, clientIP)
+	if response != nil {
+		fixEdns(msg, response)
+
+		log.Print("[INFO] response sent")
+		logEntry.Resolver = result.resolverInfo.Resolver
+		logEntry.ResolverURL = result.resolverInfo.ResolverURL
+		logEntry.Transport = transport
+		logEntry.ForwardRule = forwardRule
+		logEntry.FilterList, logEntry.FilterRule = allowed.List, allowed.Rule
+		h.LogManager.SaveLog(logEntry)
+	} else {
+		log.Print("[ERROR] response error")
+	}
+	return response
+}
+
+// cacheKeyFor membuat cache key dari nama, tipe query dan bit DO/CD. Wire
+// format meneruskan DO/CD client ke upstream, sehingga jawaban dengan RRSIG
+// (DO=1) atau yang tidak divalidasi (CD=1) tidak boleh dipakai untuk client lain
+func cacheKeyFor(msg *dns.Msg) string {
+	key := fmt.Sprintf("%s:%d", msg.Question[0].Name, msg.Question[0].Qtype)
+	if opt := msg.IsEdns0(); opt != nil && opt.Do() {
+		key += ":do"
+	}
+	if msg.CheckingDisabled {
+		key += ":cd"
+	}
+	return key
+}
+
+type upstreamResult struct {
+	responseData *doh.DOHResponse
+	resolverInfo doh.ResolverInfo
+	err          error
+}
+
+// failed bernilai true jika upstream tidak memberi jawaban yang bisa dipakai
+func (r upstreamResult) failed() bool {
+	return r.err != nil || r.responseData.Status == dns.RcodeServerFailure
+}
+
+// resolveShared menjalankan resolve satu kali per cache key; pemanggil lain
+// dengan cache key yang sama selama query berjalan menerima hasil yang sama
+func (h *Handler) resolveShared(msg *dns.Msg, clientIP string, cacheKey string, hasStale bool) <-chan singleflight.Result {
+	return h.inflight.DoChan(cacheKey, func() (interface{}, error) {
+		return h.resolve(msg, clientIP, cacheKey, hasStale), nil
+	})
+}
+
+// upstreamFor memilih grup resolver untuk nama domain: grup dari rule
+// conditional forwarding dengan suffix terpanjang, atau Upstreams default
+func (h *Handler) upstreamFor(name string) (*doh.Pool, string) {
+	if h.Forwarder != nil {
+		if client, rule, ok := h.Forwarder.Match(name); ok {
+			return client, rule
+		}
+	}
+	return h.Upstreams, ""
+}
+
+// resolve menanyakan upstream lalu menyimpan hasilnya ke cache. Kegagalan
+// hanya di-cache jika tidak ada data stale, agar data stale tidak tertimpa
+func (h *Handler) resolve(msg *dns.Msg, clientIP string, cacheKey string, hasStale bool) upstreamResult {
+	client, _ := h.upstreamFor(msg.Question[0].Name)
+	responseData, resolverInfo, err := client.Query(msg, clientIP)
+	result := upstreamResult{responseData: responseData, resolverInfo: resolverInfo, err: err}
+
+	if result.failed() && hasStale {
+		return result
+	}
+	if err != nil {
+		// SERVFAIL di-cache sebentar agar outage upstream tidak memperbesar beban
+		h.store(cacheKey, msg, &doh.DOHResponse{Status: dns.RcodeServerFailure, RA: true})
+		return result
+	}
+	h.store(cacheKey, msg, responseData)
+	return result
+}
+
+// serveStale menjawab dari data kedaluwarsa dengan TTL pendek dan
+// Extended DNS Error "Stale Answer" (RFC 8767, RFC 8914). allowed adalah rule
+// allow yang cocok dengan query, kosong jika tidak ada
+func (h *Handler) serveStale(msg *dns.Msg, response *dns.Msg, staleData interface{}, allowed filter.Match, clientIP string, cacheKey string, transport string) *dns.Msg {
+	var responseData *doh.DOHResponse
+	if err := json.Unmarshal(staleData.([]byte), &responseData); err != nil {
+		log.Printf("[ERROR] Failed to deserialize DOHResponse: %v", err)
+		response.Rcode = dns.RcodeServerFailure
+		return response
+	}
+	if responseData.Status == dns.RcodeServerFailure {
+		response.Rcode = dns.RcodeServerFailure
+		return response
+	}
+
+	if match, hop, blocked := h.blockedCNAME(msg.Question[0].Name, responseData); blocked {
+		return h.serveBlocked(msg, response, match, hop, clientIP, transport)
+	}
+
+	var logEntry logdb.DNSLog
+	response, logEntry = BuildResp(msg.Question[0].Name, response, responseData, clientIP)
+	if response == nil {
+		log.Print("[ERROR] stale response error")
+		return nil
+	}
+	fixEdns(msg, response)
+
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = min(rr.Header().Ttl, h.StaleTTL)
+			}
+		}
+	}
+	if opt := response.IsEdns0(); opt != nil {
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer})
+	}
+
+	logEntry.Resolver = "Stale"
+	logEntry.ResolverURL = "cache://" + cacheKey
+	logEntry.Transport = transport
+	_, logEntry.ForwardRule = h.upstreamFor(msg.Question[0].Name)
+	logEntry.FilterList, logEntry.FilterRule = allowed.List, allowed.Rule
+	h.LogManager.SaveLog(logEntry)
+	h.Cache.RecordStaleHit()
+	return response
+}
+
+// store menyimpan response upstream ke cache dengan TTL dari TTLPolicy,
+// termasuk jawaban negatif (NXDOMAIN/NODATA) dan SERVFAIL
+func (h *Handler) store(cacheKey string, msg *dns.Msg, responseData *doh.DOHResponse) {
+	response := new(dns.Msg)
+	response.SetReply(msg)
+	response, _ = BuildResp(msg.Question[0].Name, response, responseData, "")
+	if response == nil {
+		return
+	}
+
+	ttl := h.TTLPolicy.TTL(response)
+	if ttl == 0 {
+		return
+	}
+
+	serializedDohResp, err := json.Marshal(responseData)
+	if err != nil {
+		log.Printf("[ERROR] Failed to serialize DOHResponse: %v", err)
+		return
+	}
+	// SERVFAIL yang kedaluwarsa bukan data stale; query berikutnya harus
+	// ke upstream lagi dan hasil gagalnya boleh di-cache ulang
+	if responseData.Status == dns.RcodeServerFailure {
+		h.Cache.SetNoStale(cacheKey, serializedDohResp, ttl)
+		return
+	}
+	h.Cache.Set(cacheKey, serializedDohResp, ttl)
+}
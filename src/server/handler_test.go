@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/cache"
+	"go.blok.doh/doh"
+	"go.blok.doh/filter"
+	"go.blok.doh/logdb"
+)
+
+func TestAllowMatchLogged(t *testing.T) {
+	dir := t.TempDir()
+	blockPath := filepath.Join(dir, "block.txt")
+	allowPath := filepath.Join(dir, "allow.txt")
+	if err := os.WriteFile(blockPath, []byte("example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(allowPath, []byte("www.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := filter.New([]filter.List{
+		{Name: "ads", Path: blockPath},
+		{Name: "fixes", Path: allowPath, Allow: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := logdb.NewLogManager(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+
+	answer, _ := json.Marshal(&doh.DOHResponse{
+		Status: dns.RcodeSuccess,
+		Answer: []doh.DOHRecord{{Name: "www.example.com.", Type: int(dns.TypeA), TTL: 300, Data: "192.0.2.1"}},
+	})
+	c := cache.NewDNSTTLCacheWithOptions(cache.Options{StaleWindow: time.Hour})
+	c.Set("www.example.com.:1", answer, 300) // jawaban dari cache
+	c.Set("www.example.com.:28", answer, 0)  // hanya data stale; upstream gagal
+	h := &Handler{
+		Upstreams:   doh.NewPool([]doh.Resolver{{ID: "down", URL: "udp://127.0.0.1:1"}}),
+		Cache:       c,
+		RateLimiter: NewRateLimiterMap(100, 100),
+		LogManager:  logs,
+		Filter:      f,
+		StaleTTL:    30,
+	}
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion("www.example.com.", qtype)
+		if response := h.ServeDNS(msg, "127.0.0.1", TransportUDP); response == nil || response.Rcode != dns.RcodeSuccess {
+			t.Fatalf("ServeDNS(%s) = %v, want NOERROR", dns.TypeToString[qtype], response)
+		}
+	}
+
+	entries, err := logs.ReadLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvers := map[string]bool{}
+	for _, entry := range entries {
+		resolvers[entry.Resolver] = true
+		if entry.Blocked || entry.FilterList != "fixes" || entry.FilterRule != "www.example.com" {
+			t.Errorf("log from %s: blocked=%v list=%q rule=%q, want allow rule from fixes", entry.Resolver, entry.Blocked, entry.FilterList, entry.FilterRule)
+		}
+	}
+	if len(entries) != 2 || !resolvers["Cache"] || !resolvers["Stale"] {
+		t.Errorf("logged resolvers = %v, want Cache and Stale", resolvers)
+	}
+}
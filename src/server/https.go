@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/doh"
+)
+
+const dnsMessageContentType = "application/dns-message"
+
+// writeTimeoutMargin adalah waktu tambahan di atas QueryTimeout untuk menulis
+// response, agar client menerima SERVFAIL atau jawaban stale dari ServeDNS
+// dan bukan koneksi yang diputus
+const writeTimeoutMargin = 5 * time.Second
+
+// HTTPSServer melayani DNS-over-HTTPS (RFC 8484) untuk client downstream,
+// termasuk format JSON /resolve seperti resolver publik
+type HTTPSServer struct {
+	Port     int
+	CertFile string
+	KeyFile  string
+	Handler  *Handler
+}
+
+func (s *HTTPSServer) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", s.handleDNSQuery)
+	mux.HandleFunc("/resolve", s.handleResolve)
+
+	srv := &http.Server{
+		Addr:        fmt.Sprintf(":%d", s.Port),
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+		IdleTimeout: 90 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	// QueryTimeout 0 berarti tanpa batas, sehingga WriteTimeout juga tidak dipasang
+	if s.Handler.QueryTimeout > 0 {
+		srv.WriteTimeout = s.Handler.QueryTimeout + writeTimeoutMargin
+	}
+
+	log.Printf("[INFO] HTTPS (DoH) server started on port %d\n", s.Port)
+	if err := srv.ListenAndServeTLS(s.CertFile, s.KeyFile); err != nil {
+		log.Fatalf("[ERROR] Failed to start HTTPS server: %v", err)
+	}
+}
+
+// handleDNSQuery menangani GET ?dns=<base64url> dan POST application/dns-message
+func (s *HTTPSServer) handleDNSQuery(w http.ResponseWriter, r *http.Request) {
+	var packet []byte
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		packet, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		// parameter media type (misal "; charset=...") diabaikan
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != dnsMessageContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		packet, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(packet); err != nil || len(msg.Question) == 0 {
+		http.Error(w, "invalid DNS message", http.StatusBadRequest)
+		return
+	}
+
+	response, ok := s.serve(w, r, msg)
+	if !ok {
+		return
+	}
+
+	responseBytes, err := response.Pack()
+	if err != nil {
+		log.Printf("[ERROR] Failed to serialize DNS response: %v", err)
+		http.Error(w, "failed to serialize response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dnsMessageContentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(response)))
+	w.Write(responseBytes)
+}
+
+// handleResolve menangani format JSON /resolve?name=&type=
+func (s *HTTPSServer) handleResolve(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	qtype := dns.TypeA
+	if typeParam := r.URL.Query().Get("type"); typeParam != "" {
+		if n, err := strconv.Atoi(typeParam); err == nil {
+			qtype = uint16(n)
+		} else if t, ok := dns.StringToType[strings.ToUpper(typeParam)]; ok {
+			qtype = t
+		} else {
+			http.Error(w, "invalid type parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.CheckingDisabled = r.URL.Query().Get("cd") == "1" || r.URL.Query().Get("cd") == "true"
+
+	response, ok := s.serve(w, r, msg)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", minTTL(response)))
+	json.NewEncoder(w).Encode(msgToDOHResponse(response))
+}
+
+// serve menjalankan query lewat Handler. Query yang ditolak rate limiter
+// dijawab 429; kegagalan lain dijawab SERVFAIL agar client tidak mengira
+// dirinya dibatasi. ok bernilai false jika response HTTP sudah ditulis
+func (s *HTTPSServer) serve(w http.ResponseWriter, r *http.Request, msg *dns.Msg) (*dns.Msg, bool) {
+	response, err := s.Handler.Serve(msg, hostIP(r.RemoteAddr), TransportDoH)
+	if errors.Is(err, ErrRateLimited) {
+		http.Error(w, "query refused", http.StatusTooManyRequests)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to serve DoH query for %s: %v", msg.Question[0].Name, err)
+		response = new(dns.Msg)
+		response.SetRcode(msg, dns.RcodeServerFailure)
+		response.RecursionAvailable = true
+	}
+	return response, true
+}
+
+// msgToDOHResponse mengubah dns.Msg menjadi format JSON yang dipakai resolver publik
+func msgToDOHResponse(msg *dns.Msg) *doh.DOHResponse {
+	resp := &doh.DOHResponse{
+		Status: msg.Rcode,
+		TC:     msg.Truncated,
+		RD:     msg.RecursionDesired,
+		RA:     msg.RecursionAvailable,
+		AD:     msg.AuthenticatedData,
+		CD:     msg.CheckingDisabled,
+	}
+	for _, q := range msg.Question {
+		resp.Question = append(resp.Question, doh.DOHQuestion{Name: q.Name, Type: int(q.Qtype)})
+	}
+	for _, rr := range msg.Answer {
+		resp.Answer = append(resp.Answer, rrToDOHRecord(rr))
+	}
+	for _, rr := range msg.Ns {
+		resp.Authority = append(resp.Authority, rrToDOHRecord(rr))
+	}
+	return resp
+}
+
+func rrToDOHRecord(rr dns.RR) doh.DOHRecord {
+	hdr := rr.Header()
+	return doh.DOHRecord{
+		Name: hdr.Name,
+		Type: int(hdr.Rrtype),
+		TTL:  int(hdr.Ttl),
+		Data: strings.TrimPrefix(rr.String(), hdr.String()),
+	}
+}
+
+// minTTL mengembalikan TTL terkecil dari response, dipakai untuk header Cache-Control
+func minTTL(msg *dns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/cache"
+	"go.blok.doh/doh"
+)
+
+func TestHandleResolveErrors(t *testing.T) {
+	c := cache.NewDNSTTLCache()
+	c.Set("example.com.:1", []byte("not json"), 60) // data cache rusak: ServeDNS tidak punya jawaban
+	s := &HTTPSServer{Handler: &Handler{
+		Cache:       c,
+		RateLimiter: NewRateLimiterMap(0, 1), // hanya satu query per client
+	}}
+
+	rec := httptest.NewRecorder()
+	s.handleResolve(rec, httptest.NewRequest(http.MethodGet, "/resolve?name=example.com&type=A", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("failed query status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var resp doh.DOHResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != dns.RcodeServerFailure {
+		t.Errorf("failed query rcode = %s, want SERVFAIL", dns.RcodeToString[resp.Status])
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleResolve(rec, httptest.NewRequest(http.MethodGet, "/resolve?name=example.com&type=A", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("rate limited query status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
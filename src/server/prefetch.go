@@ -0,0 +1,69 @@
+package server
+
+import (
+	"log"
+
+	"github.com/miekg/dns"
+)
+
+// Prefetcher memperbarui entry cache populer di background sebelum
+// kedaluwarsa, sehingga client tidak perlu menunggu round trip DoH
+type Prefetcher struct {
+	MinHits uint32 // jumlah hit minimal agar entry di-prefetch
+
+	sem chan struct{}
+}
+
+// NewPrefetcher membuat Prefetcher dengan batas prefetch yang berjalan bersamaan
+func NewPrefetcher(minHits uint32, concurrency int) *Prefetcher {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Prefetcher{
+		MinHits: minHits,
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// prefetch menjalankan query ulang ke upstream di background. Jika batas
+// concurrency sudah penuh, prefetch dilewati dan entry kedaluwarsa seperti biasa.
+// clientIP dan transport berasal dari query yang memicu prefetch
+func (h *Handler) prefetch(msg *dns.Msg, clientIP string, cacheKey string, transport string) {
+	select {
+	case h.Prefetcher.sem <- struct{}{}:
+	default:
+		log.Printf("[DEBUG] Prefetch skipped for %s, too many prefetches running", cacheKey)
+		return
+	}
+
+	query := msg.Copy()
+	go func() {
+		defer func() { <-h.Prefetcher.sem }()
+
+		// hasStale=true: kegagalan tidak menimpa entry yang masih berlaku
+		shared := <-h.resolveShared(query, clientIP, cacheKey, true)
+		result := shared.Val.(upstreamResult)
+		// SERVFAIL tidak disimpan ke cache, sehingga bukan prefetch yang berhasil
+		if result.failed() {
+			if result.err != nil {
+				log.Printf("[WARN] Prefetch failed for %s: %v", cacheKey, result.err)
+			} else {
+				log.Printf("[WARN] Prefetch failed for %s: upstream answered %s", cacheKey, dns.RcodeToString[result.responseData.Status])
+			}
+			return
+		}
+
+		response := new(dns.Msg)
+		response.SetReply(query)
+		response, logEntry := BuildResp(query.Question[0].Name, response, result.responseData, clientIP)
+		if response == nil {
+			return
+		}
+		log.Printf("[INFO] Prefetched %s from %s", cacheKey, result.resolverInfo.Resolver)
+		logEntry.Resolver = "Prefetch"
+		logEntry.ResolverURL = result.resolverInfo.ResolverURL
+		logEntry.Transport = transport
+		_, logEntry.ForwardRule = h.upstreamFor(query.Question[0].Name)
+		h.LogManager.SaveLog(logEntry)
+	}()
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/cache"
+	"go.blok.doh/doh"
+	"go.blok.doh/logdb"
+)
+
+// startUDPResolver menjalankan server DNS UDP lokal yang selalu menjawab rcode
+func startUDPResolver(t *testing.T, rcode int) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetRcode(req, rcode)
+		if rcode == dns.RcodeSuccess {
+			rr, _ := dns.NewRR(req.Question[0].Name + " 300 IN A 192.0.2.1")
+			reply.Answer = append(reply.Answer, rr)
+		}
+		w.WriteMsg(reply)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return "udp://" + pc.LocalAddr().String()
+}
+
+func TestPrefetchLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		rcode   int
+		wantLog bool
+	}{
+		{name: "success", rcode: dns.RcodeSuccess, wantLog: true},
+		{name: "servfail", rcode: dns.RcodeServerFailure, wantLog: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := logdb.NewLogManager(filepath.Join(t.TempDir(), "logs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer logs.Close()
+			h := &Handler{
+				Upstreams:  doh.NewPool([]doh.Resolver{{ID: "local", URL: startUDPResolver(t, tt.rcode)}}),
+				Cache:      cache.NewDNSTTLCache(),
+				LogManager: logs,
+				Prefetcher: NewPrefetcher(1, 1),
+			}
+
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			h.prefetch(msg, "127.0.0.1", cacheKeyFor(msg), TransportDoT)
+			// prefetch selesai begitu slot concurrency dilepas
+			for deadline := time.Now().Add(5 * time.Second); len(h.Prefetcher.sem) > 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("prefetch did not finish")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			entries, err := logs.ReadLogs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantLog {
+				if len(entries) != 0 {
+					t.Errorf("failed prefetch logged %d entries, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].Resolver != "Prefetch" || entries[0].Transport != TransportDoT {
+				t.Errorf("prefetch log = %+v, want one Prefetch entry over %s", entries, TransportDoT)
+			}
+		})
+	}
+}
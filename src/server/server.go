@@ -1,316 +1,238 @@
 package server
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
-	"go.blok.doh/cache"
 	"go.blok.doh/doh"
 	"go.blok.doh/logdb"
 )
 
-type SOARecord struct {
-	PrimaryNS  string
-	AdminEmail string
-	Serial     int
-	Refresh    int
-	Retry      int
-	Expire     int
-	MinimumTTL int
-}
 type UDPServer struct {
-	Port        int
-	BufferSize  int
-	DOHClient   *doh.DOHClient
-	Cache       *cache.DNSTTLCache
-	RateLimiter *RateLimiterMap
+	Port       int
+	BufferSize int
+	Handler    *Handler
 }
 
-func ParseSOA(soaString string) (*SOARecord, error) {
-	soaParts := strings.Fields(soaString)
-	if len(soaParts) < 7 {
-		return nil, fmt.Errorf("invalid SOA record format")
+func BuildResp(domain string, response *dns.Msg, responseData *doh.DOHResponse, clientIP string) (*dns.Msg, logdb.DNSLog) {
+	dnsLog := logdb.DNSLog{
+		Timestamp: time.Now().UnixNano(),
+		ClientIP:  clientIP,
+		Query:     domain,
+		QueryType: int(response.Question[0].Qtype),
+		Resolver:  "DOH",
+	}
+	// **Response wire format dari upstream, diteruskan apa adanya**
+	if len(responseData.Wire) > 0 {
+		upstream := new(dns.Msg)
+		if err := upstream.Unpack(responseData.Wire); err != nil {
+			log.Printf("[ERROR] Failed to parse wire response: %v", err)
+			return nil, logdb.DNSLog{}
+		}
+		response.Rcode = upstream.Rcode
+		response.RecursionAvailable = upstream.RecursionAvailable
+		response.AuthenticatedData = upstream.AuthenticatedData
+		response.Answer = upstream.Answer
+		response.Ns = upstream.Ns
+		response.Extra = upstream.Extra
+		for _, rr := range append(upstream.Answer, upstream.Ns...) {
+			dnsLog.Response = append(dnsLog.Response, logRecord(rr))
+		}
+		return response, dnsLog
 	}
 
-	serial, err := strconv.Atoi(soaParts[2])
-	if err != nil {
-		return nil, fmt.Errorf("invalid serial number")
+	response.Rcode = responseData.Status
+	response.RecursionAvailable = responseData.RA
+	response.AuthenticatedData = responseData.AD
+
+	// **Proses Answer Section**
+	for _, answer := range responseData.Answer {
+		rr, err := newRR(answer, domain)
+		if err != nil {
+			log.Printf("[WARN] Skipping answer %s type %d: %v", answer.Name, answer.Type, err)
+			continue
+		}
+		response.Answer = append(response.Answer, rr)
+		dnsLog.Response = append(dnsLog.Response, logRecord(rr))
 	}
-	refresh, err := strconv.Atoi(soaParts[3])
-	if err != nil {
-		return nil, fmt.Errorf("invalid refresh value")
+
+	// **Proses Authority Section**, berisi SOA untuk NXDOMAIN/NODATA
+	for _, authority := range responseData.Authority {
+		rr, err := newRR(authority, domain)
+		if err != nil {
+			log.Printf("[WARN] Skipping authority %s type %d: %v", authority.Name, authority.Type, err)
+			continue
+		}
+		response.Ns = append(response.Ns, rr)
+		dnsLog.Response = append(dnsLog.Response, logRecord(rr))
 	}
-	retry, err := strconv.Atoi(soaParts[4])
-	if err != nil {
-		return nil, fmt.Errorf("invalid retry value")
+
+	if len(response.Answer) == 0 && response.Rcode == dns.RcodeSuccess {
+		log.Printf("[INFO] NODATA for %s, got %d authority records", domain, len(response.Ns))
 	}
-	expire, err := strconv.Atoi(soaParts[5])
-	if err != nil {
-		return nil, fmt.Errorf("invalid expire value")
+	return response, dnsLog
+}
+
+// newRR mengubah record JSON menjadi dns.RR lewat parsing presentation format,
+// sehingga semua tipe RR (SRV, PTR, CAA, HTTPS, SVCB, NAPTR, DS, DNSKEY, TLSA, ...)
+// didukung. Owner diambil dari record.Name; domain hanya dipakai jika kosong
+func newRR(record doh.DOHRecord, domain string) (dns.RR, error) {
+	owner := record.Name
+	if owner == "" {
+		owner = domain
+	}
+
+	// TXT tidak bisa lewat parser zone: data tanpa quote (Google) akan terpecah
+	// per spasi dan ";" dianggap komentar
+	switch uint16(record.Type) {
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: rrHeader(owner, dns.TypeTXT, record.TTL), Txt: txtStrings(record.Data)}, nil
+	case dns.TypeSPF:
+		return &dns.SPF{Hdr: rrHeader(owner, dns.TypeSPF, record.TTL), Txt: txtStrings(record.Data)}, nil
+	}
+
+	rrtype, ok := dns.TypeToString[uint16(record.Type)]
+	if !ok {
+		rrtype = fmt.Sprintf("TYPE%d", record.Type) // RFC 3597
 	}
-	minTTL, err := strconv.Atoi(soaParts[6])
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(owner), record.TTL, rrtype, record.Data))
 	if err != nil {
-		return nil, fmt.Errorf("invalid minimum TTL value")
+		return nil, err
+	}
+	if rr == nil {
+		return nil, fmt.Errorf("empty record data")
 	}
+	return rr, nil
+}
 
-	adminEmail := soaParts[1]
-
-	return &SOARecord{
-		PrimaryNS:  soaParts[0],
-		AdminEmail: adminEmail,
-		Serial:     serial,
-		Refresh:    refresh,
-		Retry:      retry,
-		Expire:     expire,
-		MinimumTTL: minTTL,
-	}, nil
+func rrHeader(owner string, rrtype uint16, ttl int) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   dns.Fqdn(owner),
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(ttl),
+	}
 }
 
-func BuildResp(conn *net.UDPConn, domain string, response *dns.Msg, responseData *doh.DOHResponse, remoteAddr *net.UDPAddr) (*dns.Msg, logdb.DNSLog) {
-	hasAnswer := false
-	dnsLog := logdb.DNSLog{
-		Timestamp: time.Now().UnixNano(),
-		ClientIP:  remoteAddr.IP.String(),
-		Query:     domain,
-		QueryType: int(response.Question[0].Qtype),
-		Resolver:  "DOH",
+// txtStrings memecah data TXT menjadi character-string. Cloudflare mengirim
+// string ber-quote ("a" "b"), escape di dalamnya dibiarkan seperti presentation
+// format miekg/dns. Google mengirim teks apa adanya tanpa quote, dipecah per 255 byte
+func txtStrings(data string) []string {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, `"`) {
+		var parts []string
+		for len(data) > 255 {
+			parts = append(parts, escapeTXT(data[:255]))
+			data = data[255:]
+		}
+		return append(parts, escapeTXT(data))
 	}
-	// **Proses Answer Section**
-	for _, answer := range responseData.Answer {
-		switch answer.Type {
-		case int(dns.TypeA):
-			ip := net.ParseIP(answer.Data)
-			if ip != nil {
-				response.Answer = append(response.Answer, &dns.A{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(domain),
-						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(answer.TTL),
-					},
-					A: ip,
-				})
-				dnsLog.Response = append(dnsLog.Response, struct {
-					Name  string `json:"name"`
-					Type  int    `json:"type"`
-					Class int    `json:"class"`
-					TTL   int    `json:"TTL"`
-					Data  string `json:"data"`
-				}{
-					Name:  dns.Fqdn(domain),
-					Type:  int(dns.TypeA),
-					Class: int(dns.ClassINET),
-					TTL:   int(answer.TTL),
-					Data:  answer.Data,
-				})
-				hasAnswer = true
-			}
-		case int(dns.TypeAAAA):
-			ip := net.ParseIP(answer.Data)
-			if ip != nil {
-				response.Answer = append(response.Answer, &dns.AAAA{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(domain),
-						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(answer.TTL),
-					},
-					AAAA: ip,
-				})
-
-				dnsLog.Response = append(dnsLog.Response, struct {
-					Name  string `json:"name"`
-					Type  int    `json:"type"`
-					Class int    `json:"class"`
-					TTL   int    `json:"TTL"`
-					Data  string `json:"data"`
-				}{
-					Name:  dns.Fqdn(domain),
-					Type:  int(dns.TypeAAAA),
-					Class: int(dns.ClassINET),
-					TTL:   int(answer.TTL),
-					Data:  answer.Data,
-				})
-
-				hasAnswer = true
+
+	var parts []string
+	var current strings.Builder
+	inQuote, escaped := false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case escaped:
+			current.WriteByte(c)
+			escaped = false
+		case c == '\\' && inQuote:
+			current.WriteByte(c)
+			escaped = true
+		case c == '"':
+			if inQuote {
+				parts = append(parts, current.String())
+				current.Reset()
 			}
-		case int(dns.TypeCNAME):
-			response.Answer = append(response.Answer, &dns.CNAME{
-				Hdr: dns.RR_Header{
-					Name:   dns.Fqdn(domain),
-					Rrtype: dns.TypeCNAME,
-					Class:  dns.ClassINET,
-					Ttl:    uint32(answer.TTL),
-				},
-				Target: dns.Fqdn(answer.Data),
-			})
-
-			dnsLog.Response = append(dnsLog.Response, struct {
-				Name  string `json:"name"`
-				Type  int    `json:"type"`
-				Class int    `json:"class"`
-				TTL   int    `json:"TTL"`
-				Data  string `json:"data"`
-			}{
-				Name:  dns.Fqdn(domain),
-				Type:  int(dns.TypeCNAME),
-				Class: int(dns.ClassINET),
-				TTL:   int(answer.TTL),
-				Data:  answer.Data,
-			})
-			hasAnswer = true
-		case int(dns.TypeMX):
-			response.Answer = append(response.Answer, &dns.MX{
-				Hdr: dns.RR_Header{
-					Name:   dns.Fqdn(domain),
-					Rrtype: dns.TypeMX,
-					Class:  dns.ClassINET,
-					Ttl:    uint32(answer.TTL),
-				},
-				Preference: 10, // Default priority
-				Mx:         dns.Fqdn(answer.Data),
-			})
-
-			dnsLog.Response = append(dnsLog.Response, struct {
-				Name  string `json:"name"`
-				Type  int    `json:"type"`
-				Class int    `json:"class"`
-				TTL   int    `json:"TTL"`
-				Data  string `json:"data"`
-			}{
-				Name:  dns.Fqdn(domain),
-				Type:  int(dns.TypeMX),
-				Class: int(dns.ClassINET),
-				TTL:   int(answer.TTL),
-				Data:  answer.Data,
-			})
-
-			hasAnswer = true
-		case int(dns.TypeTXT):
-			response.Answer = append(response.Answer, &dns.TXT{
-				Hdr: dns.RR_Header{
-					Name:   dns.Fqdn(domain),
-					Rrtype: dns.TypeTXT,
-					Class:  dns.ClassINET,
-					Ttl:    uint32(answer.TTL),
-				},
-				Txt: []string{answer.Data},
-			})
-
-			dnsLog.Response = append(dnsLog.Response, struct {
-				Name  string `json:"name"`
-				Type  int    `json:"type"`
-				Class int    `json:"class"`
-				TTL   int    `json:"TTL"`
-				Data  string `json:"data"`
-			}{
-				Name:  dns.Fqdn(domain),
-				Type:  int(dns.TypeTXT),
-				Class: int(dns.ClassINET),
-				TTL:   int(answer.TTL),
-				Data:  strings.Join([]string{answer.Data}, " "),
-			})
-
-			hasAnswer = true
+			inQuote = !inQuote
+		case inQuote:
+			current.WriteByte(c)
 		}
 	}
+	if inQuote {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
 
-	// **Cek Authority**
-
-	if !hasAnswer && len(responseData.Authority) > 0 {
-		log.Printf("[INFO] No Answer found, but Authority section exists, got %d", len(responseData.Authority))
-
-		for _, authority := range responseData.Authority {
-
-			switch int(authority.Type) {
-			case int(dns.TypeNS):
-				response.Ns = append(response.Ns, &dns.NS{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(domain),
-						Rrtype: dns.TypeNS,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(authority.TTL),
-					},
-					Ns: dns.Fqdn(authority.Data),
-				})
-
-				dnsLog.Response = append(dnsLog.Response, struct {
-					Name  string `json:"name"`
-					Type  int    `json:"type"`
-					Class int    `json:"class"`
-					TTL   int    `json:"TTL"`
-					Data  string `json:"data"`
-				}{
-					Name:  dns.Fqdn(domain),
-					Type:  int(dns.TypeNS),
-					Class: int(dns.ClassINET),
-					TTL:   int(authority.TTL),
-					Data:  authority.Data,
-				})
-
-			case int(dns.TypeSOA):
-				soaRecord, err := ParseSOA(authority.Data)
-				if err != nil {
-					fmt.Println("Error:", err)
-					return nil, logdb.DNSLog{}
-				}
-				response.Ns = append(response.Ns, &dns.SOA{
-					Hdr: dns.RR_Header{
-						Name:   dns.Fqdn(domain),
-						Rrtype: dns.TypeSOA,
-						Class:  dns.ClassINET,
-						Ttl:    uint32(authority.TTL),
-					},
-					Ns:      dns.Fqdn(soaRecord.PrimaryNS),
-					Mbox:    soaRecord.AdminEmail,
-					Serial:  uint32(soaRecord.Serial),
-					Refresh: uint32(soaRecord.Refresh),
-					Retry:   uint32(soaRecord.Retry),
-					Expire:  uint32(soaRecord.Expire),
-					Minttl:  uint32(soaRecord.MinimumTTL),
-				})
-
-				dnsLog.Response = append(dnsLog.Response, struct {
-					Name  string `json:"name"`
-					Type  int    `json:"type"`
-					Class int    `json:"class"`
-					TTL   int    `json:"TTL"`
-					Data  string `json:"data"`
-				}{
-					Name:  dns.Fqdn(domain),
-					Type:  int(dns.TypeSOA),
-					Class: int(dns.ClassINET),
-					TTL:   int(authority.TTL),
-					Data:  authority.Data,
-				})
+func escapeTXT(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
+
+// logRecord mengubah dns.RR menjadi record untuk DNSLog
+func logRecord(rr dns.RR) logdb.DNSRecord {
+	hdr := rr.Header()
+	return logdb.DNSRecord{
+		Name:  hdr.Name,
+		Type:  int(hdr.Rrtype),
+		Class: int(hdr.Class),
+		TTL:   int(hdr.Ttl),
+		Data:  strings.TrimPrefix(rr.String(), hdr.String()),
+	}
+}
+
+// fixEdns menyesuaikan response dengan query client: OPT hanya dikirim jika
+// client memakai EDNS0, ECS hanya jika client mengirimnya, dan bit AD hanya
+// jika client meminta AD atau DO (RFC 6840)
+func fixEdns(req *dns.Msg, response *dns.Msg) {
+	reqOpt := req.IsEdns0()
+	respOpt := response.IsEdns0()
+
+	if !req.AuthenticatedData && (reqOpt == nil || !reqOpt.Do()) {
+		response.AuthenticatedData = false
+	}
 
+	if reqOpt == nil {
+		if respOpt != nil {
+			extra := response.Extra[:0]
+			for _, rr := range response.Extra {
+				if rr.Header().Rrtype != dns.TypeOPT {
+					extra = append(extra, rr)
+				}
 			}
+			response.Extra = extra
 		}
+		return
 	}
 
-	if !hasAnswer && len(response.Ns) == 0 {
-		response.Rcode = dns.RcodeNameError
+	if respOpt == nil {
+		response.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		return
 	}
-	// Serialize response
-	responseBytes, err := response.Pack()
-	if err != nil {
-		log.Printf("[ERROR] Failed to serialize DNS response: %v", err)
-		return nil, logdb.DNSLog{}
+
+	hasSubnet := false
+	for _, o := range reqOpt.Option {
+		if o.Option() == dns.EDNS0SUBNET {
+			hasSubnet = true
+		}
+	}
+	if !hasSubnet {
+		options := respOpt.Option[:0]
+		for _, o := range respOpt.Option {
+			if o.Option() != dns.EDNS0SUBNET {
+				options = append(options, o)
+			}
+		}
+		respOpt.Option = options
 	}
+	respOpt.SetUDPSize(reqOpt.UDPSize())
+}
 
-	_, err = conn.WriteToUDP(responseBytes, remoteAddr)
-	if err != nil {
-		log.Printf("[ERROR] Failed to send response: %v", err)
-		return nil, logdb.DNSLog{}
+// udpPayloadSize mengembalikan ukuran response UDP maksimal yang diiklankan client (EDNS0), minimal 512 byte
+func udpPayloadSize(msg *dns.Msg) int {
+	if opt := msg.IsEdns0(); opt != nil && opt.UDPSize() > dns.MinMsgSize {
+		return int(opt.UDPSize())
 	}
-	return response, dnsLog
+	return dns.MinMsgSize
 }
+
 func (u *UDPServer) Start() {
 
 	addr := fmt.Sprintf(":%d", u.Port)
@@ -325,127 +247,44 @@ func (u *UDPServer) Start() {
 	}
 	defer conn.Close()
 
-	logManager, err := logdb.NewLogManager("./dns_logs")
-	if err != nil {
-		fmt.Println("Error membuka database:", err)
-		return
-	}
-	defer logManager.Close()
-
-	u.Cache.StartCleanupLoop(30 * time.Second)
-
 	log.Printf("[INFO] UDP server started on port %d\n", u.Port)
 
-	buffer := make([]byte, u.BufferSize)
 	for {
+		// buffer baru per paket, karena diproses di goroutine terpisah
+		buffer := make([]byte, u.BufferSize)
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 			log.Printf("[ERROR] Failed to read from UDP: %v", err)
 			continue
 		}
 
-		go func(n int, remoteAddr *net.UDPAddr) {
-			ipStr := remoteAddr.IP.String()
-			limiter := u.RateLimiter.GetLimiter(ipStr)
-			if !limiter.Allow() {
-				log.Printf("[WARN] Rate limit exceeded for %s", ipStr)
-
-				// Kirim respon DNS SERVFAIL
-				// msg := new(dns.Msg)
-				// msg.SetRcodeFormatError(&dns.Msg{}) // kosong dulu biar format benar
-				// msg.Rcode = dns.RcodeServerFailure
-
-				// respBytes, err := msg.Pack()
-				// if err != nil {
-				// 	log.Printf("[ERROR] Failed to pack rate-limit SERVFAIL response: %v", err)
-				// 	return
-				// }
-
-				// _, err = conn.WriteToUDP(respBytes, remoteAddr)
-				// if err != nil {
-				// 	log.Printf("[ERROR] Failed to send SERVFAIL response: %v", err)
-				// }
-				return
-			}
+		go func(packet []byte, remoteAddr *net.UDPAddr) {
 			msg := new(dns.Msg)
-			err = msg.Unpack(buffer[:n])
-			if err != nil {
+			if err := msg.Unpack(packet); err != nil {
 				log.Printf("[ERROR] Failed to parse DNS query: %v", err)
 				return
 			}
 
-			if len(msg.Question) == 0 {
-				log.Printf("[ERROR] No question found in DNS query")
+			response := u.Handler.ServeDNS(msg, remoteAddr.IP.String(), TransportUDP)
+			if response == nil {
 				return
 			}
 
-			domain := msg.Question[0].Name
-			qtype := msg.Question[0].Qtype
-			cacheKey := fmt.Sprintf("%s:%d", domain, qtype)
-
-			log.Printf("[INFO] Received query for %s (type: %d) from %v", domain, qtype, remoteAddr)
-
-			response := new(dns.Msg)
-			response.SetReply(msg)
-			response.Compress = true
-
-			if cachedData, found := u.Cache.Get(cacheKey); found {
-				log.Printf("[INFO] Found %t,  Cache hit for %s", found, cacheKey)
-				var responseData *doh.DOHResponse
-				err := json.Unmarshal(cachedData.([]byte), &responseData)
-				if err != nil {
-					log.Printf("[ERROR] Failed to deserialize DOHResponse: %v", err)
-				}
-				var logEntry logdb.DNSLog
-				response, logEntry = BuildResp(conn, domain, response, responseData, remoteAddr)
-				if response != nil {
-					log.Print("[INFO] response from cache sent")
-					logEntry.Resolver = "Cache"
-					logEntry.ResolverURL = "cache://" + cacheKey
-
-					logManager.SaveLog(logEntry)
-
-				} else {
-					log.Print("[ERROR] response from cache error")
-
-				}
-				return
+			// Set TC dan buang record jika response melebihi ukuran yang diterima client
+			response.Truncate(udpPayloadSize(msg))
+			if response.Truncated {
+				log.Printf("[INFO] Response for %s truncated, client should retry over TCP", msg.Question[0].Name)
 			}
 
-			responseData, resolverInfo, err := u.DOHClient.Query(domain, qtype, ipStr)
-
+			responseBytes, err := response.Pack()
 			if err != nil {
-				log.Printf("[ERROR] Failed to resolve domain: %v", err)
-				response.Rcode = dns.RcodeServerFailure
-			} else {
-
-				ttl := uint32(0) // Default TTL
-
-				if len(responseData.Answer) > 0 {
-					ttl = uint32(responseData.Answer[0].TTL)
-				} else if len(responseData.Authority) > 0 {
-					ttl = uint32(responseData.Authority[0].TTL)
-				}
-				serializedDohResp, err := json.Marshal(responseData)
-				if err != nil {
-					log.Printf("[ERROR] Failed to serialize DOHResponse: %v", err)
-					return
-				}
-
-				u.Cache.Set(cacheKey, serializedDohResp, ttl)
-				var logEntry logdb.DNSLog
-				response, logEntry = BuildResp(conn, domain, response, responseData, remoteAddr)
-				if response != nil {
-					log.Print("[INFO] response sent")
-					logEntry.Resolver = resolverInfo.Resolver
-					logEntry.ResolverURL = resolverInfo.ResolverURL
-					logManager.SaveLog(logEntry)
-				} else {
-					log.Print("[ERROR] response error")
-
-				}
+				log.Printf("[ERROR] Failed to serialize DNS response: %v", err)
+				return
 			}
 
-		}(n, remoteAddr)
+			if _, err := conn.WriteToUDP(responseBytes, remoteAddr); err != nil {
+				log.Printf("[ERROR] Failed to send response: %v", err)
+			}
+		}(buffer[:n], remoteAddr)
 	}
 }
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.blok.doh/doh"
+)
+
+func TestNewRR(t *testing.T) {
+	tests := []struct {
+		name   string
+		record doh.DOHRecord
+		domain string
+		want   string
+	}{
+		{
+			name:   "SRV",
+			record: doh.DOHRecord{Name: "_sip._udp.example.com.", Type: int(dns.TypeSRV), TTL: 300, Data: "10 5 5060 sip.example.com."},
+			domain: "example.com.",
+			want:   "_sip._udp.example.com.\t300\tIN\tSRV\t10 5 5060 sip.example.com.",
+		},
+		{
+			name:   "PTR",
+			record: doh.DOHRecord{Name: "8.8.8.8.in-addr.arpa.", Type: int(dns.TypePTR), TTL: 21600, Data: "dns.google."},
+			domain: "8.8.8.8.in-addr.arpa.",
+			want:   "8.8.8.8.in-addr.arpa.\t21600\tIN\tPTR\tdns.google.",
+		},
+		{
+			name:   "CAA",
+			record: doh.DOHRecord{Name: "google.com.", Type: int(dns.TypeCAA), TTL: 86400, Data: `0 issue "pki.goog"`},
+			domain: "google.com.",
+			want:   "google.com.\t86400\tIN\tCAA\t0 issue \"pki.goog\"",
+		},
+		{
+			name:   "HTTPS",
+			record: doh.DOHRecord{Name: "crypto.cloudflare.com.", Type: int(dns.TypeHTTPS), TTL: 300, Data: `1 . alpn="h3,h2" ipv4hint=162.159.135.79,162.159.136.79`},
+			domain: "crypto.cloudflare.com.",
+			want:   "crypto.cloudflare.com.\t300\tIN\tHTTPS\t1 . alpn=\"h3,h2\" ipv4hint=\"162.159.135.79,162.159.136.79\"",
+		},
+		{
+			// Cloudflare mengirim HTTPS/SVCB dalam format RFC 3597, byte dipisah spasi
+			name:   "HTTPS RFC 3597",
+			record: doh.DOHRecord{Name: "crypto.cloudflare.com", Type: int(dns.TypeHTTPS), TTL: 300, Data: `\# 25 00 01 00 00 01 00 06 02 68 33 02 68 32 00 04 00 08 a2 9f 87 4f a2 9f 88 4f`},
+			domain: "crypto.cloudflare.com",
+			want:   "crypto.cloudflare.com.\t300\tIN\tHTTPS\t1 . alpn=\"h3,h2\" ipv4hint=\"162.159.135.79,162.159.136.79\"",
+		},
+		{
+			name:   "SVCB",
+			record: doh.DOHRecord{Name: "_dns.resolver.arpa.", Type: int(dns.TypeSVCB), TTL: 300, Data: `1 one.one.one.one. alpn="h2,h3" port=443 ipv4hint=1.1.1.1`},
+			domain: "_dns.resolver.arpa.",
+			want:   "_dns.resolver.arpa.\t300\tIN\tSVCB\t1 one.one.one.one. alpn=\"h2,h3\" port=\"443\" ipv4hint=\"1.1.1.1\"",
+		},
+		{
+			name:   "SVCB RFC 3597",
+			record: doh.DOHRecord{Name: "_dns.resolver.arpa", Type: int(dns.TypeSVCB), TTL: 300, Data: `\# 43 0001036f6e65036f6e65036f6e65036f6e6500000100060268320268330003000201bb0004000401010101`},
+			domain: "_dns.resolver.arpa",
+			want:   "_dns.resolver.arpa.\t300\tIN\tSVCB\t1 one.one.one.one. alpn=\"h2,h3\" port=\"443\" ipv4hint=\"1.1.1.1\"",
+		},
+		{
+			name:   "NAPTR",
+			record: doh.DOHRecord{Name: "sip.example.com.", Type: int(dns.TypeNAPTR), TTL: 3600, Data: `100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`},
+			domain: "sip.example.com.",
+			want:   "sip.example.com.\t3600\tIN\tNAPTR\t100 10 \"S\" \"SIP+D2U\" \"\" _sip._udp.example.com.",
+		},
+		{
+			name:   "DS",
+			record: doh.DOHRecord{Name: "cloudflare.com.", Type: int(dns.TypeDS), TTL: 3600, Data: "2371 13 2 32996839a6d808afe3eb4a795a0e6a7a39a76fc52ff228b22b76f6d63826f2b9"},
+			domain: "cloudflare.com.",
+			want:   "cloudflare.com.\t3600\tIN\tDS\t2371 13 2 32996839A6D808AFE3EB4A795A0E6A7A39A76FC52FF228B22B76F6D63826F2B9",
+		},
+		{
+			name:   "DNSKEY",
+			record: doh.DOHRecord{Name: "cloudflare.com.", Type: int(dns.TypeDNSKEY), TTL: 3600, Data: "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ=="},
+			domain: "cloudflare.com.",
+			want:   "cloudflare.com.\t3600\tIN\tDNSKEY\t257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ==",
+		},
+		{
+			name:   "TLSA",
+			record: doh.DOHRecord{Name: "_25._tcp.mail.example.com.", Type: int(dns.TypeTLSA), TTL: 3600, Data: "3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6"},
+			domain: "mail.example.com.",
+			want:   "_25._tcp.mail.example.com.\t3600\tIN\tTLSA\t3 1 1 0c72ac70b745ac19998811b131d662c9ac69dbdbe7cb23e5b514b56664c5d3d6",
+		},
+		{
+			name:   "owner from domain when name is empty",
+			record: doh.DOHRecord{Type: int(dns.TypeA), TTL: 60, Data: "192.0.2.1"},
+			domain: "example.com",
+			want:   "example.com.\t60\tIN\tA\t192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, err := newRR(tt.record, tt.domain)
+			if err != nil {
+				t.Fatalf("newRR() error = %v", err)
+			}
+			if got := rr.String(); got != tt.want {
+				t.Errorf("newRR() = %q, want %q", got, tt.want)
+			}
+			if rr.Header().Rrtype != uint16(tt.record.Type) {
+				t.Errorf("newRR() type = %d, want %d", rr.Header().Rrtype, tt.record.Type)
+			}
+			if tt.record.Name != "" && rr.Header().Name != dns.Fqdn(tt.record.Name) {
+				t.Errorf("newRR() owner = %s, want %s from answer name", rr.Header().Name, dns.Fqdn(tt.record.Name))
+			}
+		})
+	}
+}
+
+func TestTxtStrings(t *testing.T) {
+	long := strings.Repeat("a", 250) + "; " + strings.Repeat("b", 48) // 300 byte
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{name: "quoted", data: `"v=spf1 include:_spf.google.com ~all"`, want: []string{"v=spf1 include:_spf.google.com ~all"}},
+		{name: "quoted multiple strings", data: `"part one" "part two"`, want: []string{"part one", "part two"}},
+		{name: "quoted escaped quote and semicolon", data: `"say \"hi\"; bye"`, want: []string{`say \"hi\"; bye`}},
+		{name: "quoted escaped backslash", data: `"a\\b"`, want: []string{`a\\b`}},
+		{name: "quoted split over 255", data: `"` + long[:255] + `" "` + long[255:] + `"`, want: []string{long[:255], long[255:]}},
+		{name: "unquoted semicolon", data: "v=DKIM1; k=rsa; p=MIGf", want: []string{"v=DKIM1; k=rsa; p=MIGf"}},
+		{name: "unquoted quote and backslash", data: `say "hi" \ bye`, want: []string{`say \"hi\" \\ bye`}},
+		{name: "unquoted exactly 255", data: long[:255], want: []string{long[:255]}},
+		{name: "unquoted over 255", data: long, want: []string{long[:255], long[255:]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := txtStrings(tt.data)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("txtStrings() = %q, want %q", got, tt.want)
+			}
+
+			// setiap character-string harus muat di wire format (maksimal 255 byte)
+			rr, err := newRR(doh.DOHRecord{Name: "example.com.", Type: int(dns.TypeTXT), TTL: 300, Data: tt.data}, "example.com.")
+			if err != nil {
+				t.Fatalf("newRR() error = %v", err)
+			}
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeTXT)
+			msg.Answer = append(msg.Answer, rr)
+			if _, err := msg.Pack(); err != nil {
+				t.Errorf("Pack() error = %v", err)
+			}
+		})
+	}
+}
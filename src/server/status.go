@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"go.blok.doh/cache"
+	"go.blok.doh/doh"
+	"go.blok.doh/filter"
+)
+
+// StatusServer menampilkan kesehatan resolver upstream dan statistik cache
+// dalam format JSON di /status
+type StatusServer struct {
+	Port      int
+	Upstreams *doh.Pool
+	Groups    map[string]*doh.Pool // grup conditional forwarding
+	Cache     *cache.DNSTTLCache
+	Filter    *filter.Filter // nil = blocklist nonaktif
+}
+
+type statusResponse struct {
+	Resolvers []doh.ResolverStats            `json:"resolvers"`
+	Groups    map[string][]doh.ResolverStats `json:"groups,omitempty"`
+	Cache     cache.Stats                    `json:"cache"`
+	Filter    []filter.ListStats             `json:"filter,omitempty"`
+}
+
+func (s *StatusServer) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+
+	log.Printf("[INFO] Status server started on port %d\n", s.Port)
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", s.Port), mux); err != nil {
+		log.Fatalf("[ERROR] Failed to start status server: %v", err)
+	}
+}
+
+func (s *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := statusResponse{
+		Resolvers: s.Upstreams.Stats(),
+		Groups:    make(map[string][]doh.ResolverStats, len(s.Groups)),
+		Cache:     s.Cache.Stats(),
+	}
+	for name, client := range s.Groups {
+		status.Groups[name] = client.Stats()
+	}
+	if s.Filter != nil {
+		status.Filter = s.Filter.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
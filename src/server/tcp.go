@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TCPServer melayani query DNS lewat TCP (RFC 7766), dipakai client
+// yang retry setelah menerima response UDP dengan bit TC
+type TCPServer struct {
+	Port        int
+	IdleTimeout time.Duration
+	Handler     *Handler
+}
+
+func (t *TCPServer) Start() {
+	addr := fmt.Sprintf(":%d", t.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to start TCP server: %v", err)
+	}
+	defer listener.Close()
+
+	log.Printf("[INFO] TCP server started on port %d\n", t.Port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("[ERROR] Failed to accept TCP connection: %v", err)
+			continue
+		}
+		go serveStream(conn, t.Handler, t.IdleTimeout, TransportTCP)
+	}
+}
+
+// serveStream membaca query ber-prefix panjang 2 byte dari satu koneksi
+// TCP atau TLS. Setiap query diproses di goroutine sendiri (pipelining),
+// sehingga response bisa dikirim tidak berurutan sesuai waktu selesainya
+func serveStream(conn net.Conn, handler *Handler, idleTimeout time.Duration, transport string) {
+	defer conn.Close()
+
+	clientIP := hostIP(conn.RemoteAddr().String())
+	reader := bufio.NewReader(conn)
+
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		var length uint16
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			if err != io.EOF {
+				log.Printf("[DEBUG] Closing connection from %s: %v", clientIP, err)
+			}
+			return
+		}
+		if length == 0 {
+			continue
+		}
+
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			log.Printf("[ERROR] Failed to read DNS query from %s: %v", clientIP, err)
+			return
+		}
+
+		wg.Add(1)
+		go func(packet []byte) {
+			defer wg.Done()
+
+			msg := new(dns.Msg)
+			if err := msg.Unpack(packet); err != nil {
+				log.Printf("[ERROR] Failed to parse DNS query: %v", err)
+				return
+			}
+
+			response := handler.ServeDNS(msg, clientIP, transport)
+			if response == nil {
+				return
+			}
+
+			responseBytes, err := response.Pack()
+			if err != nil {
+				log.Printf("[ERROR] Failed to serialize DNS response: %v", err)
+				return
+			}
+
+			frame := make([]byte, 2+len(responseBytes))
+			binary.BigEndian.PutUint16(frame, uint16(len(responseBytes)))
+			copy(frame[2:], responseBytes)
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+			if _, err := conn.Write(frame); err != nil {
+				log.Printf("[ERROR] Failed to send response: %v", err)
+			}
+		}(packet)
+	}
+}
+
+// hostIP mengambil bagian IP dari alamat "host:port"
+func hostIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
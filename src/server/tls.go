@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"time"
+)
+
+// TLSServer melayani DNS-over-TLS (RFC 7858), dipakai Android "Private DNS"
+// dan systemd-resolved. Framing dan pipelining sama dengan TCPServer
+type TLSServer struct {
+	Port        int
+	CertFile    string
+	KeyFile     string
+	IdleTimeout time.Duration
+	Handler     *Handler
+}
+
+func (t *TLSServer) Start() {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	addr := fmt.Sprintf(":%d", t.Port)
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to start TLS server: %v", err)
+	}
+	defer listener.Close()
+
+	log.Printf("[INFO] TLS (DoT) server started on port %d\n", t.Port)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("[ERROR] Failed to accept TLS connection: %v", err)
+			continue
+		}
+		go serveStream(conn, t.Handler, t.IdleTimeout, TransportDoT)
+	}
+}